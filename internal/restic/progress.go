@@ -1,12 +1,19 @@
 package restic
 
 import (
+	"context"
 	"fmt"
+	"math"
+	"math/bits"
 	"os"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/restic/restic/internal/debug"
+	"github.com/restic/restic/internal/errors"
+
 	"golang.org/x/crypto/ssh/terminal"
 )
 
@@ -35,26 +42,207 @@ type Progress struct {
 	OnDone   ProgressFunc
 	fnM      sync.Mutex
 
+	// OnFirstData is called once per run when the first report with a
+	// non-zero number of bytes arrives, for example to switch the display
+	// from scanning to transferring.
+	OnFirstData func()
+
+	// OnError is called by ReportError for each error encountered by the
+	// operation, after it has been counted in Stat.Errors.
+	OnError func(err error)
+
+	// OnCallbackError is called when one of the callbacks failed, for
+	// example because it panicked.
+	OnCallbackError func(err error)
+
+	progressConfig
+
 	cur        Stat
 	curM       sync.Mutex
 	start      time.Time
-	c          *time.Ticker
+	c          Ticker
 	cancel     chan struct{}
 	o          *sync.Once
-	d          time.Duration
 	lastUpdate time.Time
 
 	running bool
+
+	// intervalChanged notifies the reporter that SetInterval replaced c
+	intervalChanged chan struct{}
+
+	// reporterDone is closed when the reporter started by the last run has
+	// returned, it is nil if no reporter was started
+	reporterDone chan struct{}
+
+	total Stat
+
+	// lifetime are the statistics reported since p was created, they are
+	// not reset. Protected by curM.
+	lifetime Stat
+
+	// fast holds the reports from ReportFast which are not yet added to cur,
+	// see foldFast. Until the time in fastUntil (Unix nanoseconds, zero if
+	// closed), ReportFast only adds to it.
+	fast      AtomicStat
+	fastUntil atomic.Int64
+
+	// lastActivity is the time of the most recent Report
+	lastActivity time.Time
+	stalled      bool
+
+	// activeFiles is the number of files between ReportFileStart and
+	// ReportFileDone
+	activeFiles uint64
+
+	// maxDepth is the deepest directory level passed to ReportDepth
+	maxDepth int
+
+	// idleTime is the sum of the gaps between reports which exceeded the
+	// stall timeout, see ActiveRate
+	idleTime time.Duration
+
+	// lastProgressTime is the time of the most recent Report which
+	// increased the number of bytes
+	lastProgressTime time.Time
+
+	// doneReason is the reason passed to DoneWithReason for the last run
+	doneReason DoneReason
+
+	// seenData is set when the first bytes have been reported
+	seenData bool
+
+	// reported is set by the first Report of a run, ticked by the first
+	// tick, see WithSkipEmptyFirstTick
+	reported bool
+	ticked   bool
+
+	// history holds the samples recorded on each tick, samples older than
+	// historyDuration are removed
+	history []ProgressSample
+
+	// rateCounts are the number of ticks per bucket of rateBuckets, the
+	// last one counts the rates above all buckets
+	rateCounts []uint64
+
+	// lastWasTick records whether the most recent update came from the ticker
+	lastWasTick bool
+
+	// time spent in OnUpdate
+	callbackCount uint64
+	callbackTime  time.Duration
+
+	// abandoned is closed when a callback which exceeded the callback timeout
+	// has returned, it is nil if no callback is in flight. Protected by fnM.
+	abandoned chan struct{}
+
+	// updates are the subscribers created by Updates() which are still
+	// open, closedStats the final counters of those closed by the last
+	// Done. updateSeq is the sequence number of the last update sent to
+	// them. All are protected by fnM.
+	updates     []*subscriber
+	closedStats []SubscriberStats
+	updateSeq   uint64
+
+	// subscriptions are the callbacks registered with Subscribe, protected
+	// by fnM
+	subscriptions []*subscription
+
+	// doneHooks are called with the final statistics in Done, protected by
+	// fnM
+	doneHooks []*doneHook
+
+	// finished is the result of the current run, it is replaced by Start.
+	// Protected by curM.
+	finished *runResult
+
+	// minimum rate set with SetMinRate, protected by curM
+	minRate   float64
+	onMinRate func()
+	// belowMinRate is the time since the rate is below minRate, zero if it
+	// is not
+	belowMinRate    time.Time
+	minRateViolated bool
+
+	// milestones are registered with RegisterMilestone, protected by curM
+	milestones []milestone
+
+	// weights set with SetCompletionModel, protected by curM
+	bytesWeight, itemsWeight float64
+
+	// rate sampling, updated on each tick of the reporter. itemEMA is the
+	// smoothed rate of the items counted by the ItemSelector.
+	lastSampleTime  time.Time
+	lastSampleBytes uint64
+	lastSampleItems uint64
+	rateSamples     int
+	ema             float64
+	itemEMA         float64
 }
 
-// Stat captures newly done parts of the operation.
+// progressConfig is the configuration of a Progress set by NewProgress and
+// the options. Only the interval d can be changed while the Progress is
+// running, by SetInterval.
+type progressConfig struct {
+	d     time.Duration
+	name  string
+	clock Clock
+
+	// noTicker disables the reporter goroutine, updates are then only
+	// delivered from Report and Done.
+	noTicker       bool
+	updateOnReport bool
+
+	stallTimeout time.Duration
+	onStall      func(lastActivity time.Time)
+
+	historyDuration time.Duration
+
+	// rateBuckets are the sorted upper bounds of the buckets set with
+	// WithRateHistogram
+	rateBuckets []float64
+
+	callbackTimeout time.Duration
+
+	// bounds of the interval for WithAdaptiveInterval, zero if disabled
+	minInterval time.Duration
+	maxInterval time.Duration
+
+	// itemSelector counts the items of a Stat, nil means FilesAndDirs
+	itemSelector ItemSelector
+
+	// alignTicks schedules every tick at a multiple of the interval
+	alignTicks bool
+
+	// skipEmptyFirstTick suppresses the first tick of a run if it fires
+	// before any Report
+	skipEmptyFirstTick bool
+
+	// minRateGrace is how long the rate must stay below the minimum set
+	// with SetMinRate before it is reported
+	minRateGrace time.Duration
+}
+
+// Stat captures newly done parts of the operation. A valid Stat never stores
+// more bytes than it processed (StoredBytes <= Bytes), see Validate.
 type Stat struct {
-	Files  uint64
-	Dirs   uint64
-	Bytes  uint64
-	Trees  uint64
-	Blobs  uint64
-	Errors uint64
+	Files  uint64 `json:"files"`
+	Dirs   uint64 `json:"dirs"`
+	Bytes  uint64 `json:"bytes"`
+	Trees  uint64 `json:"trees"`
+	Blobs  uint64 `json:"blobs"`
+	Errors uint64 `json:"errors"`
+
+	// StoredBytes is the number of bytes actually written to the repository
+	// for the Bytes processed, after deduplication and compression.
+	StoredBytes uint64 `json:"stored_bytes"`
+
+	// Skipped is the number of files which were not read again because they
+	// did not change.
+	Skipped uint64 `json:"skipped"`
+
+	// CompressedBytes is the size of the blobs for the Bytes processed after
+	// compression, but before deduplication.
+	CompressedBytes uint64 `json:"compressed_bytes"`
 }
 
 // ProgressFunc is used to report progress back to the user.
@@ -65,57 +253,161 @@ type ProgressFunc func(s Stat, runtime time.Duration, ticker bool)
 // called when new data arrives or at least every d interval. The function
 // OnDone is called when Done() is called. Both functions are called
 // synchronously and can use shared state.
-func NewProgress() *Progress {
+func NewProgress(opts ...ProgressOption) *Progress {
+	p := &Progress{progressConfig: defaultConfig()}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// defaultConfig returns the configuration used by NewProgress before the
+// options are applied.
+func defaultConfig() progressConfig {
 	var d time.Duration
 	if isTerminal {
 		d = time.Second
 	}
-	return &Progress{d: d}
+
+	return progressConfig{
+		d:              d,
+		clock:          realClock{},
+		updateOnReport: isTerminal,
+	}
 }
 
-// Start resets and runs the progress reporter.
+// now returns the current time of the clock. A Progress which was not created
+// by NewProgress has no clock, the system time is used then.
+func (p *Progress) now() time.Time {
+	if p.clock == nil {
+		return time.Now()
+	}
+	return p.clock.Now()
+}
+
+// CloneConfig returns a new Progress which is not running and has the same
+// configuration as p, for example the interval, the clock and the options
+// passed to NewProgress. Neither the counters and the total nor the OnStart,
+// OnUpdate and OnDone functions are copied. It is safe to call while p is
+// running, the clone then gets the current interval.
+func (p *Progress) CloneConfig() *Progress {
+	if p == nil {
+		return nil
+	}
+
+	// the interval can be changed by SetInterval and the adaptive interval
+	p.curM.Lock()
+	defer p.curM.Unlock()
+	return &Progress{progressConfig: p.progressConfig}
+}
+
+// Start resets and runs the progress reporter. If the reporter of the
+// previous run is still shutting down, Start waits for it to return first, so
+// it must not be called from a callback run by the reporter.
 func (p *Progress) Start() {
 	if p == nil || p.running {
 		return
 	}
 
+	// the reporter of the previous run may still be shutting down, it must
+	// not see the state of the new run
+	if p.reporterDone != nil {
+		<-p.reporterDone
+		p.reporterDone = nil
+	}
+
+	// the zero value gets the same defaults as NewProgress
+	if p.clock == nil {
+		def := defaultConfig()
+		p.clock = def.clock
+		p.updateOnReport = def.updateOnReport
+		if p.d == 0 {
+			p.d = def.d
+		}
+	}
+
 	p.o = &sync.Once{}
 	p.cancel = make(chan struct{})
 	p.running = true
 	p.Reset()
-	p.start = time.Now()
+	p.start = p.now()
+	p.resetRun()
+	p.intervalChanged = make(chan struct{}, 1)
 	p.c = nil
-	if p.d != 0 {
-		p.c = time.NewTicker(p.d)
+	if p.d != 0 && !p.noTicker {
+		p.c = p.newReporterTicker(p.start)
 	}
 
+	debug.Log("progress %q started", p.name)
+
 	if p.OnStart != nil {
 		p.OnStart()
 	}
 
-	go p.reporter()
+	if !p.noTicker {
+		p.reporterDone = make(chan struct{})
+		go p.reporter(p.reporterDone)
+	}
+}
+
+// resetRun resets the state kept for a single run to the values at Start().
+func (p *Progress) resetRun() {
+	p.curM.Lock()
+	defer p.curM.Unlock()
+
+	p.doneReason = DoneCompleted
+	p.finished = &runResult{done: make(chan struct{})}
+	p.lastActivity = p.start
+	p.lastProgressTime = p.start
+	p.stalled = false
+	p.idleTime = 0
+	p.activeFiles = 0
+	p.maxDepth = 0
+	p.seenData = false
+	p.reported = false
+	p.ticked = false
+	p.fastUntil.Store(0)
+	// reports from ReportFast which raced the end of the previous run
+	p.fast.take()
+	p.lastWasTick = false
+	p.history = nil
+	p.rateCounts = nil
+	if len(p.rateBuckets) > 0 {
+		p.rateCounts = make([]uint64, len(p.rateBuckets)+1)
+	}
+	p.callbackCount = 0
+	p.callbackTime = 0
+
+	p.lastSampleTime = p.start
+	p.lastSampleBytes = 0
+	p.lastSampleItems = 0
+	p.rateSamples = 0
+	p.ema = 0
+	p.itemEMA = 0
+
+	p.belowMinRate = time.Time{}
+	p.minRateViolated = false
 }
 
-// Reset resets all statistic counters to zero.
+// Reset resets all statistic counters to zero. It may also be called before
+// Start or after Done, for example to clear a Progress which is reused.
 func (p *Progress) Reset() {
 	if p == nil {
 		return
 	}
 
-	if !p.running {
-		panic("resetting a non-running Progress")
-	}
-
 	p.curM.Lock()
+	p.foldFast()
 	p.cur = Stat{}
 	p.curM.Unlock()
 }
 
 // Report adds the statistics from s to the current state and tries to report
-// the accumulated statistics via the feedback channel.
-func (p *Progress) Report(s Stat) {
+// the accumulated statistics via the feedback channel. The accumulated
+// statistics including s are returned.
+func (p *Progress) Report(s Stat) Stat {
 	if p == nil {
-		return
+		return Stat{}
 	}
 
 	if !p.running {
@@ -123,65 +415,465 @@ func (p *Progress) Report(s Stat) {
 	}
 
 	p.curM.Lock()
+	prev := p.curLocked()
 	p.cur.Add(s)
+	p.lifetime.Add(s)
+	p.reported = true
 	cur := p.cur
+	var milestones []milestoneEvent
+	if len(p.milestones) > 0 {
+		milestones = p.reachedMilestones(prev, cur)
+	}
 	needUpdate := false
-	if isTerminal && time.Since(p.lastUpdate) > minTickerTime {
-		p.lastUpdate = time.Now()
+	now := p.now()
+	p.idleTime += p.idleGap(now)
+	p.lastActivity = now
+	p.stalled = false
+	// an update is also due when the clock jumped back before the last one
+	if p.updateOnReport && (now.Sub(p.lastUpdate) > minTickerTime || now.Before(p.lastUpdate)) {
+		p.lastUpdate = now
 		needUpdate = true
 	}
+	if s.Bytes > 0 {
+		p.lastProgressTime = now
+	}
+	firstData := false
+	if s.Bytes > 0 && !p.seenData {
+		p.seenData = true
+		firstData = true
+	}
+	p.openFastWindow(now)
 	p.curM.Unlock()
 
+	if firstData && p.OnFirstData != nil {
+		p.callLocked(p.OnFirstData)
+	}
+
+	if len(milestones) > 0 {
+		p.callLocked(func() {
+			for _, ev := range milestones {
+				ev.fn(ev.reached, cur)
+			}
+		})
+	}
+
 	if needUpdate {
 		p.updateProgress(cur, false)
 	}
 
+	return cur
+}
+
+// ReportStored reports that logical bytes were processed, of which only stored
+// bytes needed to be written to the repository.
+func (p *Progress) ReportStored(logical, stored uint64) {
+	p.Report(Stat{Bytes: logical, StoredBytes: stored})
+}
+
+// ReportBlobSizes reports a blob with uncompressed bytes, which were
+// compressed to compressed bytes.
+func (p *Progress) ReportBlobSizes(uncompressed, compressed uint64) {
+	p.Report(Stat{Bytes: uncompressed, CompressedBytes: compressed})
+}
+
+// ReportSkipped reports that count unchanged files were skipped.
+func (p *Progress) ReportSkipped(count uint64) {
+	p.Report(Stat{Skipped: count})
+}
+
+// ReportError counts err in Stat.Errors and passes it to OnError.
+func (p *Progress) ReportError(err error) {
+	if p == nil {
+		return
+	}
+
+	p.Report(Stat{Errors: 1})
+
+	if p.OnError != nil {
+		p.callLocked(func() {
+			p.OnError(err)
+		})
+	}
+}
+
+// ReportFileStart records that processing a file has started. Large files
+// are often reported in chunks while they are read, but only counted in
+// Stat.Files once they are done, ActiveFiles tells how many are in flight.
+func (p *Progress) ReportFileStart() {
+	if p == nil {
+		return
+	}
+
+	p.curM.Lock()
+	p.activeFiles++
+	p.curM.Unlock()
+}
+
+// ReportFileDone records that processing a file started with ReportFileStart
+// has ended. It does not count the file in Stat.Files, that is still done by
+// Report.
+func (p *Progress) ReportFileDone() {
+	if p == nil {
+		return
+	}
+
+	p.curM.Lock()
+	if p.activeFiles > 0 {
+		p.activeFiles--
+	}
+	p.curM.Unlock()
+}
+
+// ActiveFiles returns the number of files which were started with
+// ReportFileStart but are not done yet.
+func (p *Progress) ActiveFiles() uint64 {
+	if p == nil {
+		return 0
+	}
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+	return p.activeFiles
+}
+
+// ReportDepth records that a directory at depth d below the starting point
+// was reached, MaxDepth returns the deepest one. It is only a diagnostic for
+// how deep a scan goes and does not change the counters or the updates.
+func (p *Progress) ReportDepth(d int) {
+	if p == nil {
+		return
+	}
+
+	p.curM.Lock()
+	if d > p.maxDepth {
+		p.maxDepth = d
+	}
+	p.curM.Unlock()
+}
+
+// MaxDepth returns the deepest directory level passed to ReportDepth in the
+// current run, or zero if none was reported.
+func (p *Progress) MaxDepth() int {
+	if p == nil {
+		return 0
+	}
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+	return p.maxDepth
+}
+
+// ReportFiles reports n files with a total of bytes in a single Report.
+func (p *Progress) ReportFiles(n uint64, bytes uint64) {
+	p.Report(Stat{Files: n, Bytes: bytes})
+}
+
+// ReportDirs reports n dirs in a single Report.
+func (p *Progress) ReportDirs(n uint64) {
+	p.Report(Stat{Dirs: n})
+}
+
+// Flush calls OnUpdate with the current statistics right away, regardless of
+// the throttling of updates from Report. Together with WithoutTicker, this
+// allows driving all updates from the caller.
+func (p *Progress) Flush() {
+	if p == nil || !p.running {
+		return
+	}
+
+	p.curM.Lock()
+	cur := p.curLocked()
+	p.lastUpdate = p.now()
+	p.curM.Unlock()
+
+	p.updateProgress(cur, false)
 }
 
 func (p *Progress) updateProgress(cur Stat, ticker bool) {
+	runtime := p.elapsed(p.now())
+
+	p.curM.Lock()
+	p.lastWasTick = ticker
+	maxDepth := p.maxDepth
+	p.curM.Unlock()
+
+	p.callLocked(func() {
+		p.callOnUpdate(cur, runtime, ticker)
+		p.publish(ProgressEvent{Stat: cur, Runtime: runtime, Ticker: ticker, MaxDepth: maxDepth})
+		p.notifySubscribers(cur, runtime, ticker)
+	})
+}
+
+// callOnUpdate runs OnUpdate and measures how long it took. The caller must
+// hold fnM.
+func (p *Progress) callOnUpdate(cur Stat, runtime time.Duration, ticker bool) {
 	if p.OnUpdate == nil {
 		return
 	}
 
-	p.fnM.Lock()
-	p.OnUpdate(cur, time.Since(p.start), ticker)
-	p.fnM.Unlock()
+	start := p.now()
+	if p.callbackTimeout > 0 {
+		p.runWithTimeout(func() {
+			p.OnUpdate(cur, runtime, ticker)
+		})
+	} else {
+		p.OnUpdate(cur, runtime, ticker)
+	}
+	d := since(p.now(), start)
+
+	p.curM.Lock()
+	p.callbackCount++
+	p.callbackTime += d
+	p.curM.Unlock()
 }
 
-func (p *Progress) reporter() {
+// LastActivity returns the time of the most recent Report, or the time of
+// Start() if nothing has been reported yet. Ticks of the reporter do not
+// change it.
+func (p *Progress) LastActivity() time.Time {
 	if p == nil {
-		return
+		return time.Time{}
+	}
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+	return p.lastActivity
+}
+
+// IsStalled returns true if no bytes have been reported for at least
+// threshold. Reports which do not increase the number of bytes, for example
+// for directories, do not count as progress.
+func (p *Progress) IsStalled(threshold time.Duration) bool {
+	if p == nil {
+		return false
 	}
 
+	now := p.now()
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+	return now.Sub(p.lastProgressTime) >= threshold
+}
+
+// LastUpdateWasTick returns true if the most recent update was triggered by
+// the ticker rather than by Report or Done.
+func (p *Progress) LastUpdateWasTick() bool {
+	if p == nil {
+		return false
+	}
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+	return p.lastWasTick
+}
+
+// AverageCallbackDuration returns the average time spent in OnUpdate, it
+// helps to find out whether a slow callback delays the operation.
+func (p *Progress) AverageCallbackDuration() time.Duration {
+	if p == nil {
+		return 0
+	}
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+
+	if p.callbackCount == 0 {
+		return 0
+	}
+	return p.callbackTime / time.Duration(p.callbackCount)
+}
+
+// reporter calls OnUpdate on each tick until the run ends, then it closes
+// done.
+func (p *Progress) reporter(done chan struct{}) {
+	defer close(done)
+	defer p.recoverReporter()
+
 	updateProgress := func() {
 		p.curM.Lock()
-		cur := p.cur
+		cur := p.curLocked()
 		p.curM.Unlock()
 		p.updateProgress(cur, true)
 	}
 
-	var ticker <-chan time.Time
-	if p.c != nil {
-		ticker = p.c.C
+	tickerChan := func() <-chan time.Time {
+		p.curM.Lock()
+		defer p.curM.Unlock()
+		if p.c == nil {
+			return nil
+		}
+		return p.c.Chan()
 	}
 
+	ticker := tickerChan()
+
 	for {
 		select {
 		case <-ticker:
-			updateProgress()
+			p.alignTicker()
+			p.tick()
+		case <-p.intervalChanged:
+			ticker = tickerChan()
 		case <-forceUpdateProgress:
 			updateProgress()
 		case <-p.cancel:
-			if p.c != nil {
-				p.c.Stop()
-			}
+			p.stopTicker()
 			return
 		}
 	}
 }
 
-// Done closes the progress report.
+// stopTicker stops the ticker of the reporter.
+func (p *Progress) stopTicker() {
+	p.curM.Lock()
+	defer p.curM.Unlock()
+
+	if p.c != nil {
+		p.c.Stop()
+		p.c = nil
+	}
+}
+
+// recoverReporter must be deferred by the reporter. When a callback called by
+// the reporter panics, the ticker is stopped and the panic is passed to
+// OnCallbackError, so the process keeps running. Reports are still delivered
+// and Done works as usual, only the periodic updates end. Without an error
+// hook, the panic is not swallowed.
+func (p *Progress) recoverReporter() {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	p.stopTicker()
+
+	if p.OnCallbackError == nil {
+		panic(r)
+	}
+
+	debug.Log("progress %q: reporter panicked: %v", p.name, r)
+
+	p.callLocked(func() {
+		p.OnCallbackError(errors.Errorf("reporter panicked: %v", r))
+	})
+}
+
+// callLocked runs fn with fnM held, fnM is released even if fn panics.
+func (p *Progress) callLocked(fn func()) {
+	p.fnM.Lock()
+	defer p.fnM.Unlock()
+
+	fn()
+}
+
+// SetInterval changes the interval in which the reporter calls OnUpdate. When
+// the Progress is running, the ticker is replaced without resetting the
+// counters or the runtime. The interval must be positive.
+func (p *Progress) SetInterval(d time.Duration) error {
+	if p == nil {
+		return nil
+	}
+
+	if d <= 0 {
+		return errors.Errorf("invalid progress interval %v", d)
+	}
+
+	p.curM.Lock()
+	p.setInterval(d)
+	p.curM.Unlock()
+
+	return nil
+}
+
+// setInterval sets the interval to d and replaces the ticker if the reporter
+// is running. The caller must hold curM.
+func (p *Progress) setInterval(d time.Duration) {
+	p.d = d
+
+	if !p.running || p.noTicker {
+		return
+	}
+
+	select {
+	case <-p.cancel:
+		// the reporter is shutting down
+		return
+	default:
+	}
+
+	p.replaceTicker()
+}
+
+// replaceTicker stops the ticker of the reporter and starts a new one for the
+// current interval. The caller must hold curM.
+func (p *Progress) replaceTicker() {
+	if p.c != nil {
+		p.c.Stop()
+	}
+	p.c = p.newReporterTicker(p.now())
+
+	select {
+	case p.intervalChanged <- struct{}{}:
+	default:
+	}
+}
+
+// newReporterTicker returns a ticker for the interval. With aligned ticks,
+// it fires once at the next multiple of the interval after now and is
+// replaced on each tick.
+func (p *Progress) newReporterTicker(now time.Time) Ticker {
+	if p.alignTicks {
+		return newTicker(p.clock, alignDelay(now, p.d))
+	}
+	return newTicker(p.clock, p.d)
+}
+
+// alignDelay returns the time from now until the next multiple of d.
+func alignDelay(now time.Time, d time.Duration) time.Duration {
+	return now.Truncate(d).Add(d).Sub(now)
+}
+
+// alignTicker schedules the next tick at the next multiple of the interval
+// when aligned ticks are enabled. Rescheduling on each tick keeps the ticks
+// on the boundaries even if a tick was delivered late.
+func (p *Progress) alignTicker() {
+	p.curM.Lock()
+	defer p.curM.Unlock()
+
+	if !p.alignTicks || p.c == nil {
+		return
+	}
+
+	select {
+	case <-p.cancel:
+		// the reporter is shutting down
+		return
+	default:
+	}
+
+	p.replaceTicker()
+}
+
+// Interval returns the current interval of the ticker, zero means that
+// OnUpdate is not called periodically.
+func (p *Progress) Interval() time.Duration {
+	if p == nil {
+		return 0
+	}
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+	return p.d
+}
+
+// Done closes the progress report, the operation has completed.
 func (p *Progress) Done() {
+	p.DoneWithReason(DoneCompleted)
+}
+
+// DoneWithReason closes the progress report like Done. The reason is returned
+// by DoneReason, so that OnDone can tell how the operation ended.
+func (p *Progress) DoneWithReason(reason DoneReason) {
 	if p == nil || !p.running {
 		return
 	}
@@ -191,43 +883,657 @@ func (p *Progress) Done() {
 		close(p.cancel)
 	})
 
-	cur := p.cur
+	p.curM.Lock()
+	p.fastUntil.Store(0)
+	cur := p.curLocked()
+	maxDepth := p.maxDepth
+	finished := p.finished
+	p.lastWasTick = false
+	p.doneReason = reason
+	p.curM.Unlock()
+	runtime := p.elapsed(p.now())
+
+	debug.Log("progress %q %v after %v: %v", p.name, reason, runtime, cur)
+
+	var panicked interface{}
+
+	p.callLocked(func() {
+		if p.OnDone != nil {
+			// make sure OnDone runs even if the final update panics
+			panicked = catchPanic(func() {
+				p.callOnUpdate(cur, runtime, false)
+			})
+			if panicked != nil && p.OnCallbackError != nil {
+				p.OnCallbackError(errors.Errorf("OnUpdate panicked: %v", panicked))
+				panicked = nil
+			}
+
+			p.OnDone(cur, runtime, false)
+		}
+		p.publish(ProgressEvent{Stat: cur, Runtime: runtime, MaxDepth: maxDepth})
+		p.notifySubscribers(cur, runtime, false)
+		p.closeUpdates()
+		// a hook may remove itself
+		for _, hook := range append([]*doneHook(nil), p.doneHooks...) {
+			hook.fn(cur)
+		}
+	})
+
+	finished.stat = cur
+	finished.runtime = runtime
+	close(finished.done)
+
+	// without an error hook, the panic is not swallowed
+	if panicked != nil {
+		panic(panicked)
+	}
+}
+
+// runResult is the outcome of a run. done is closed at the end of Done, after
+// the final statistics and runtime passed to OnDone have been set.
+type runResult struct {
+	done    chan struct{}
+	stat    Stat
+	runtime time.Duration
+}
+
+// Wait blocks until the current run has ended with Done and all callbacks
+// have returned. It returns the final statistics and runtime as passed to
+// OnDone. If p has never been started, it returns right away.
+func (p *Progress) Wait() (Stat, time.Duration) {
+	if p == nil {
+		return Stat{}, 0
+	}
+
+	p.curM.Lock()
+	finished := p.finished
+	if finished == nil {
+		defer p.curM.Unlock()
+		return p.curLocked(), 0
+	}
+	p.curM.Unlock()
+
+	// the result is not changed after done is closed, even if the next run
+	// has already started
+	<-finished.done
+	return finished.stat, finished.runtime
+}
+
+// Completed returns a channel which is closed when the current run has ended
+// with Done and OnDone has returned. Unlike StopSignal, which is closed at the
+// start of Done, receiving from it guarantees that the final callbacks have
+// run. It returns nil before Start is called, a new channel is used for each
+// run.
+func (p *Progress) Completed() <-chan struct{} {
+	if p == nil {
+		return nil
+	}
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+	if p.finished == nil {
+		return nil
+	}
+	return p.finished.done
+}
+
+// Finalize ends the current run like Done, unless it has already ended. It is
+// meant to be deferred right after Start, so that OnDone is called exactly once
+// with the statistics reported so far, even on an early return. When deferred
+// directly and the function panics, the run ends with DoneError and the panic
+// continues afterwards.
+func (p *Progress) Finalize() {
+	if r := recover(); r != nil {
+		p.DoneWithReason(DoneError)
+		panic(r)
+	}
+
+	p.Done()
+}
+
+// DoneReason describes how an operation ended.
+type DoneReason int
+
+// These are the reasons passed to DoneWithReason.
+const (
+	DoneCompleted DoneReason = iota
+	DoneCancelled
+	DoneError
+)
+
+func (r DoneReason) String() string {
+	switch r {
+	case DoneCompleted:
+		return "completed"
+	case DoneCancelled:
+		return "cancelled"
+	case DoneError:
+		return "error"
+	default:
+		return fmt.Sprintf("DoneReason(%d)", int(r))
+	}
+}
+
+// DoneReason returns the reason the last run ended with. It is already set
+// when OnDone is called.
+func (p *Progress) DoneReason() DoneReason {
+	if p == nil {
+		return DoneCompleted
+	}
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+	return p.doneReason
+}
+
+// catchPanic runs fn and returns the value passed to panic, if any.
+func catchPanic(fn func()) (panicked interface{}) {
+	defer func() {
+		panicked = recover()
+	}()
+
+	fn()
+	return nil
+}
+
+// Name returns the name set with WithName.
+func (p *Progress) Name() string {
+	if p == nil {
+		return ""
+	}
+
+	return p.name
+}
+
+// StopSignal returns a channel which is closed when Done is called, so that
+// workers can stop reporting. It returns nil before Start is called, a new
+// channel is used for each run.
+func (p *Progress) StopSignal() <-chan struct{} {
+	if p == nil {
+		return nil
+	}
+
+	return p.cancel
+}
+
+// Context returns a context derived from parent which is cancelled when the
+// current run ends with Done, or earlier when parent is cancelled or its
+// deadline expires. Each run uses a new channel to signal Done, so a context
+// returned while p is not running is cancelled right away.
+func (p *Progress) Context(parent context.Context) context.Context {
+	ctx, cancel := context.WithCancel(parent)
+
+	if p == nil || !p.running {
+		cancel()
+		return ctx
+	}
+
+	stop := p.StopSignal()
+	go func() {
+		defer cancel()
+
+		select {
+		case <-stop:
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx
+}
+
+// Tick runs a tick of the reporter synchronously: it records a rate sample,
+// runs the checks for stalls and the minimum rate and calls OnUpdate with
+// ticker set. It is meant to drive the updates when p was created with
+// WithManualTick, it does nothing when p is not running.
+func (p *Progress) Tick() {
+	if p == nil || !p.running {
+		return
+	}
+
+	p.tick()
+}
+
+// tick records a rate sample and reports the accumulated statistics. It is
+// called by the reporter for each tick of the ticker.
+func (p *Progress) tick() {
+	now := p.now()
+
+	p.curM.Lock()
+	cur := p.curLocked()
+	ema := p.ema
+	if rate, ok := p.sampleRate(now, cur); ok {
+		p.recordHistory(ProgressSample{Time: now, Stat: cur, Rate: rate})
+		p.recordRate(rate)
+		p.adaptInterval(rate, ema)
+	}
+
+	stalled := false
+	lastActivity := p.lastActivity
+	if p.onStall != nil && !p.stalled && now.Sub(lastActivity) >= p.stallTimeout {
+		p.stalled = true
+		stalled = true
+	}
+	violated := p.checkMinRate(now)
+	skip := p.skipEmptyFirstTick && !p.ticked && !p.reported
+	p.ticked = true
+	p.curM.Unlock()
+
+	if stalled {
+		p.callLocked(func() {
+			p.onStall(lastActivity)
+		})
+	}
+
+	if violated {
+		p.callLocked(p.onMinRate)
+	}
+
+	if skip {
+		debug.Log("progress %q: skipping first tick, nothing was reported yet", p.name)
+		return
+	}
+
+	p.updateProgress(cur, true)
+}
+
+// elapsed returns the time since Start() at now. A clock which goes backwards
+// must not result in a negative duration, so it is clamped to zero. When the
+// clock is a MonotonicClock, it is measured with Since instead, so that all
+// runtimes reported agree with Elapsed.
+func (p *Progress) elapsed(now time.Time) time.Duration {
+	if mc, ok := p.clock.(MonotonicClock); ok {
+		if d := mc.Since(p.start); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return since(now, p.start)
+}
+
+// Elapsed returns the time since Start(), it is never negative.
+func (p *Progress) Elapsed() time.Duration {
+	if p == nil {
+		return 0
+	}
+
+	return p.elapsed(p.now())
+}
+
+// SetTotal sets the expected statistics when the operation has finished,
+// they are used to compute the estimated time remaining. A field which is zero
+// means that its total is unknown, for example the number of files when only
+// the size is known. It can be called repeatedly, for example while a scanner
+// walks the directories and the total grows.
+func (p *Progress) SetTotal(total Stat) {
+	if p == nil {
+		return
+	}
+
+	p.curM.Lock()
+	p.total = total
+	p.curM.Unlock()
+}
+
+// AddTotal adds more to the expected statistics, for example when a scanner
+// running concurrently discovers more work. It can be called at any time.
+func (p *Progress) AddTotal(more Stat) {
+	if p == nil {
+		return
+	}
+
+	p.curM.Lock()
+	p.total.Add(more)
+	p.curM.Unlock()
+}
+
+// Total returns the expected statistics set with SetTotal and AddTotal.
+func (p *Progress) Total() Stat {
+	if p == nil {
+		return Stat{}
+	}
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+	return p.total
+}
+
+// RemainingBytes returns how many of the total bytes have not been processed
+// yet, it is zero when more than the total was reported. The bool is false if
+// the total has no bytes.
+func (p *Progress) RemainingBytes() (uint64, bool) {
+	if p == nil {
+		return 0, false
+	}
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+
+	if p.total.Bytes == 0 {
+		return 0, false
+	}
+	return delta(p.total.Bytes, p.curLocked().Bytes), true
+}
 
-	if p.OnDone != nil {
-		p.fnM.Lock()
-		p.OnUpdate(cur, time.Since(p.start), false)
-		p.OnDone(cur, time.Since(p.start), false)
-		p.fnM.Unlock()
+// PercentDone returns how many of the total bytes have been processed, as a
+// value between 0 and 100. If the total has no bytes but a number of files,
+// the files processed are used instead. With a model set by
+// SetCompletionModel, the bytes and items are blended instead. Zero is
+// returned if no total is set.
+func (p *Progress) PercentDone() float64 {
+	if p == nil {
+		return 0
+	}
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+	if p.bytesWeight+p.itemsWeight > 0 {
+		return p.blendedPercent()
+	}
+	cur := p.curLocked()
+	if p.total.Bytes == 0 {
+		return percent(cur.Files, p.total.Files)
+	}
+	return percent(cur.Bytes, p.total.Bytes)
+}
+
+// PercentDoneField returns how much of the total has been processed for the
+// field of Stat returned by field, as a value between 0 and 100. The bool is
+// false if the total of that field is zero, which means it is unknown, so
+// that it is not mistaken for an operation which has not started yet.
+func (p *Progress) PercentDoneField(field func(Stat) uint64) (float64, bool) {
+	if p == nil {
+		return 0, false
+	}
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+
+	total := field(p.total)
+	if total == 0 {
+		return 0, false
 	}
+	return percent(field(p.curLocked()), total), true
+}
+
+// PercentByFiles returns how many of the total files have been processed, as
+// a value between 0 and 100.
+func (p *Progress) PercentByFiles() float64 {
+	pct, _ := p.PercentDoneField(func(s Stat) uint64 { return s.Files })
+	return pct
 }
 
-// Add accumulates other into s.
+// PercentByBytes returns how many of the total bytes have been processed.
+// Unlike PercentDone, it does not fall back to the files.
+func (p *Progress) PercentByBytes() float64 {
+	pct, _ := p.PercentDoneField(func(s Stat) uint64 { return s.Bytes })
+	return pct
+}
+
+// percent returns 100*done/total, clamped to the range 0 to 100. It is zero
+// when total is zero.
+func percent(done, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+
+	pct := 100 * float64(done) / float64(total)
+	if pct > 100 {
+		pct = 100
+	}
+	return pct
+}
+
+// Current returns the accumulated statistics.
+func (p *Progress) Current() Stat {
+	if p == nil {
+		return Stat{}
+	}
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+	return p.curLocked()
+}
+
+// Add accumulates other into s. It modifies s in place, so a local Stat can be
+// used to batch many small increments in a tight loop, which are then passed
+// to Progress.Report at once. Counters which would overflow stay at
+// math.MaxUint64 instead of wrapping around.
 func (s *Stat) Add(other Stat) {
-	s.Bytes += other.Bytes
-	s.Dirs += other.Dirs
-	s.Files += other.Files
-	s.Trees += other.Trees
-	s.Blobs += other.Blobs
-	s.Errors += other.Errors
+	add := func(a *uint64, b uint64) {
+		*a = addSaturated(*a, b)
+	}
+
+	add(&s.Bytes, other.Bytes)
+	add(&s.Dirs, other.Dirs)
+	add(&s.Files, other.Files)
+	add(&s.Trees, other.Trees)
+	add(&s.Blobs, other.Blobs)
+	add(&s.Errors, other.Errors)
+	add(&s.StoredBytes, other.StoredBytes)
+	add(&s.Skipped, other.Skipped)
+	add(&s.CompressedBytes, other.CompressedBytes)
+}
+
+// addSaturated returns a+b, or math.MaxUint64 if the sum would overflow.
+func addSaturated(a, b uint64) uint64 {
+	sum, carry := bits.Add64(a, b, 0)
+	if carry != 0 {
+		return math.MaxUint64
+	}
+	return sum
+}
+
+// Clone returns a copy of s which does not share any memory with s, so it can
+// be kept for a long time. All fields are plain counters at the moment, fields
+// of reference types added in the future must be copied here.
+func (s Stat) Clone() Stat {
+	return s
+}
+
+// Sub returns s minus other, fields which would become negative are zero.
+func (s Stat) Sub(other Stat) Stat {
+	sub := func(a, b uint64) uint64 {
+		if b > a {
+			return 0
+		}
+		return a - b
+	}
+
+	return Stat{
+		Files:  sub(s.Files, other.Files),
+		Dirs:   sub(s.Dirs, other.Dirs),
+		Bytes:  sub(s.Bytes, other.Bytes),
+		Trees:  sub(s.Trees, other.Trees),
+		Blobs:  sub(s.Blobs, other.Blobs),
+		Errors: sub(s.Errors, other.Errors),
+
+		StoredBytes: sub(s.StoredBytes, other.StoredBytes),
+		Skipped:     sub(s.Skipped, other.Skipped),
+
+		CompressedBytes: sub(s.CompressedBytes, other.CompressedBytes),
+	}
+}
+
+// SignedStat is the difference between two Stat values, fields are negative
+// when a counter went down, for example after a Reset.
+type SignedStat struct {
+	Files  int64
+	Dirs   int64
+	Bytes  int64
+	Trees  int64
+	Blobs  int64
+	Errors int64
+
+	StoredBytes int64
+	Skipped     int64
+
+	CompressedBytes int64
+}
+
+// Diff returns s minus other. Unlike Sub, fields which went down are
+// negative instead of zero. Differences which do not fit into an int64 are
+// clamped to math.MaxInt64 or math.MinInt64.
+func (s Stat) Diff(other Stat) SignedStat {
+	diff := func(a, b uint64) int64 {
+		if a >= b {
+			if a-b > math.MaxInt64 {
+				return math.MaxInt64
+			}
+			return int64(a - b)
+		}
+		if b-a >= 1<<63 {
+			return math.MinInt64
+		}
+		return -int64(b - a)
+	}
+
+	return SignedStat{
+		Files:  diff(s.Files, other.Files),
+		Dirs:   diff(s.Dirs, other.Dirs),
+		Bytes:  diff(s.Bytes, other.Bytes),
+		Trees:  diff(s.Trees, other.Trees),
+		Blobs:  diff(s.Blobs, other.Blobs),
+		Errors: diff(s.Errors, other.Errors),
+
+		StoredBytes: diff(s.StoredBytes, other.StoredBytes),
+		Skipped:     diff(s.Skipped, other.Skipped),
+
+		CompressedBytes: diff(s.CompressedBytes, other.CompressedBytes),
+	}
+}
+
+// Max returns the per-field maximum of s and other.
+func (s Stat) Max(other Stat) Stat {
+	max := func(a, b uint64) uint64 {
+		if b > a {
+			return b
+		}
+		return a
+	}
+
+	return Stat{
+		Files:  max(s.Files, other.Files),
+		Dirs:   max(s.Dirs, other.Dirs),
+		Bytes:  max(s.Bytes, other.Bytes),
+		Trees:  max(s.Trees, other.Trees),
+		Blobs:  max(s.Blobs, other.Blobs),
+		Errors: max(s.Errors, other.Errors),
+
+		StoredBytes: max(s.StoredBytes, other.StoredBytes),
+		Skipped:     max(s.Skipped, other.Skipped),
+
+		CompressedBytes: max(s.CompressedBytes, other.CompressedBytes),
+	}
+}
+
+// TotalItems returns the number of files, dirs, trees and blobs, all counted
+// equally. Like for Add, a sum which would overflow is math.MaxUint64.
+func (s Stat) TotalItems() uint64 {
+	return addSaturated(addSaturated(s.Files, s.Dirs), addSaturated(s.Trees, s.Blobs))
+}
+
+// StatWeights are the weights of the item counters of a Stat used by
+// WeightedItems.
+type StatWeights struct {
+	Files float64
+	Dirs  float64
+	Trees float64
+	Blobs float64
+}
+
+// WeightedItems returns the sum of the item counters of s, each multiplied by
+// its weight in w.
+func (s Stat) WeightedItems(w StatWeights) float64 {
+	return float64(s.Files)*w.Files +
+		float64(s.Dirs)*w.Dirs +
+		float64(s.Trees)*w.Trees +
+		float64(s.Blobs)*w.Blobs
 }
 
 func (s Stat) String() string {
-	b := float64(s.Bytes)
-	var str string
-
-	switch {
-	case s.Bytes > 1<<40:
-		str = fmt.Sprintf("%.3f TiB", b/(1<<40))
-	case s.Bytes > 1<<30:
-		str = fmt.Sprintf("%.3f GiB", b/(1<<30))
-	case s.Bytes > 1<<20:
-		str = fmt.Sprintf("%.3f MiB", b/(1<<20))
-	case s.Bytes > 1<<10:
-		str = fmt.Sprintf("%.3f KiB", b/(1<<10))
-	default:
-		str = fmt.Sprintf("%dB", s.Bytes)
+	return s.StringWithOptions(StatOptions{})
+}
+
+// StatOptions controls how StringWithOptions renders a Stat.
+type StatOptions struct {
+	// CombineObjects shows trees and blobs together as objects.
+	CombineObjects bool
+}
+
+// StringWithOptions is like String, but rendered according to opts. Stat is
+// logged often, so the string is built in a buffer on the stack instead of
+// with fmt.
+func (s Stat) StringWithOptions(opts StatOptions) string {
+	var buf [160]byte
+	b := append(buf[:0], "Stat("...)
+
+	b = strconv.AppendUint(b, s.Files, 10)
+	b = append(b, " files, "...)
+	b = strconv.AppendUint(b, s.Dirs, 10)
+	b = append(b, " dirs, "...)
+	if opts.CombineObjects {
+		b = strconv.AppendUint(b, addSaturated(s.Trees, s.Blobs), 10)
+		b = append(b, " objects, "...)
+	} else {
+		b = strconv.AppendUint(b, s.Trees, 10)
+		b = append(b, " trees, "...)
+		b = strconv.AppendUint(b, s.Blobs, 10)
+		b = append(b, " blobs, "...)
 	}
+	b = strconv.AppendUint(b, s.Errors, 10)
+	b = append(b, " errors, "...)
+	b = DefaultByteFormat.appendFormat(b, s.Bytes)
 
-	return fmt.Sprintf("Stat(%d files, %d dirs, %v trees, %v blobs, %d errors, %v)",
-		s.Files, s.Dirs, s.Trees, s.Blobs, s.Errors, str)
+	if s.StoredBytes != 0 {
+		b = append(b, ", "...)
+		b = DefaultByteFormat.appendFormat(b, s.StoredBytes)
+		b = append(b, " stored"...)
+	}
+	if s.CompressedBytes != 0 {
+		b = append(b, ", "...)
+		b = DefaultByteFormat.appendFormat(b, s.CompressedBytes)
+		b = append(b, " compressed"...)
+	}
+	if s.Skipped != 0 {
+		b = append(b, ", "...)
+		b = strconv.AppendUint(b, s.Skipped, 10)
+		b = append(b, " skipped"...)
+	}
+
+	return string(append(b, ')'))
+}
+
+// Validate returns an error if s violates one of the invariants documented on
+// Stat.
+func (s Stat) Validate() error {
+	if s.StoredBytes > s.Bytes {
+		return errors.Errorf("invalid stat: %d stored bytes exceed %d processed bytes", s.StoredBytes, s.Bytes)
+	}
+
+	return nil
+}
+
+// CompressionRatio returns the ratio of the bytes processed to the compressed
+// bytes, so that it only reflects compression and not deduplication. If no
+// compressed bytes were reported, the bytes stored are used instead. Zero is
+// returned when neither was reported.
+func (s Stat) CompressionRatio() float64 {
+	compressed := s.CompressedBytes
+	if compressed == 0 {
+		compressed = s.StoredBytes
+	}
+	if compressed == 0 {
+		return 0
+	}
+	return float64(s.Bytes) / float64(compressed)
+}
+
+// SavingsRatio returns the fraction of the bytes processed which did not need
+// to be stored, as a value between 0 and 1. Zero is returned when nothing was
+// processed or more was stored than processed.
+func (s Stat) SavingsRatio() float64 {
+	if s.Bytes == 0 || s.StoredBytes >= s.Bytes {
+		return 0
+	}
+	return float64(s.Bytes-s.StoredBytes) / float64(s.Bytes)
 }
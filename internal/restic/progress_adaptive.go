@@ -0,0 +1,44 @@
+package restic
+
+import "time"
+
+// burstFactor is how much the rate of a tick must exceed the smoothed rate to
+// count as a burst for the adaptive interval.
+const burstFactor = 1.5
+
+// clampInterval returns d limited to the range min to max. A zero d is
+// replaced by max.
+func clampInterval(d, min, max time.Duration) time.Duration {
+	switch {
+	case d == 0 || d > max:
+		return max
+	case d < min:
+		return min
+	default:
+		return d
+	}
+}
+
+// adaptInterval adjusts the interval when adaptive updates are enabled. rate
+// is the rate measured for the current tick and ema the smoothed rate before
+// that tick. The caller must hold curM.
+func (p *Progress) adaptInterval(rate, ema float64) {
+	if p.maxInterval == 0 {
+		return
+	}
+
+	d := p.d
+	switch {
+	case rate == 0:
+		d *= 2
+	case rate > burstFactor*ema:
+		d /= 2
+	default:
+		return
+	}
+
+	d = clampInterval(d, p.minInterval, p.maxInterval)
+	if d != p.d {
+		p.setInterval(d)
+	}
+}
@@ -0,0 +1,104 @@
+package restic
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProgressAdaptiveInterval(t *testing.T) {
+	clock := newFakeClock()
+	min, max := 250*time.Millisecond, 4*time.Second
+	p := NewProgress(WithClock(clock), WithoutTicker(), WithAdaptiveInterval(min, max))
+	p.Start()
+	defer p.Done()
+
+	if d := p.Interval(); d != max {
+		t.Fatalf("expected the interval to start at %v, got %v", max, d)
+	}
+
+	// idle ticks keep the interval at the upper bound
+	for i := 0; i < 3; i++ {
+		clock.Advance(p.Interval())
+		p.tick()
+		if d := p.Interval(); d != max {
+			t.Fatalf("interval left the upper bound when idle: %v", d)
+		}
+	}
+
+	// bursts of increasing size shorten the interval down to the lower bound
+	var intervals []time.Duration
+	amount := uint64(1000)
+	for i := 0; i < 8; i++ {
+		clock.Advance(p.Interval())
+		p.Report(Stat{Bytes: amount})
+		amount *= 10
+		p.tick()
+		intervals = append(intervals, p.Interval())
+	}
+
+	for i, d := range intervals {
+		if d < min || d > max {
+			t.Errorf("interval %v out of bounds", d)
+		}
+		if i > 0 && d > intervals[i-1] {
+			t.Errorf("interval grew during a burst: %v", intervals)
+		}
+	}
+	if last := intervals[len(intervals)-1]; last != min {
+		t.Errorf("expected the interval to reach the lower bound, got %v", intervals)
+	}
+
+	// idle again, the interval grows back to the upper bound
+	for i := 0; i < 6; i++ {
+		clock.Advance(p.Interval())
+		p.tick()
+	}
+	if d := p.Interval(); d != max {
+		t.Errorf("interval did not grow back to %v when idle, got %v", max, d)
+	}
+}
+
+func TestProgressAdaptiveIntervalInvalid(t *testing.T) {
+	var tests = []struct {
+		min, max time.Duration
+	}{
+		{0, time.Second},
+		{-time.Second, time.Second},
+		{2 * time.Second, time.Second},
+	}
+
+	for _, test := range tests {
+		p := NewProgress(WithoutTicker(), WithAdaptiveInterval(test.min, test.max))
+		if err := p.SetInterval(time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if p.minInterval != 0 || p.maxInterval != 0 {
+			t.Errorf("bounds %v, %v: adaptive interval enabled", test.min, test.max)
+		}
+
+		// the interval is never adapted
+		p.curM.Lock()
+		p.adaptInterval(1e9, 1)
+		p.curM.Unlock()
+		if d := p.Interval(); d != time.Second {
+			t.Errorf("bounds %v, %v: interval changed to %v", test.min, test.max, d)
+		}
+	}
+}
+
+func TestClampInterval(t *testing.T) {
+	var tests = []struct {
+		d, want time.Duration
+	}{
+		{0, 10 * time.Second},
+		{time.Millisecond, time.Second},
+		{5 * time.Second, 5 * time.Second},
+		{time.Minute, 10 * time.Second},
+	}
+
+	for _, test := range tests {
+		if got := clampInterval(test.d, time.Second, 10*time.Second); got != test.want {
+			t.Errorf("clampInterval(%v): want %v, got %v", test.d, test.want, got)
+		}
+	}
+}
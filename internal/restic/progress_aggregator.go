@@ -0,0 +1,133 @@
+package restic
+
+import (
+	"sync"
+	"time"
+)
+
+// Aggregator combines the statistics of several Progress instances, for
+// example of operations running in parallel.
+//
+// While a child is running, its statistics are read live on every call to
+// Current. When the child is done, its final contribution is added to the
+// aggregator once and the child is dropped, so it is not counted twice and
+// can be discarded by the caller. Reports after the child was restarted are
+// not counted.
+type Aggregator struct {
+	clock Clock
+	start time.Time
+
+	m        sync.Mutex
+	children []*aggregatorChild
+	// done is the contribution of the children which are done
+	done Stat
+}
+
+type aggregatorChild struct {
+	p *Progress
+	// base are the statistics of the child when it was added
+	base Stat
+	// hook is the done hook registered with the child
+	hook *doneHook
+}
+
+// doneHook is a function registered to be called with the final statistics
+// in Done.
+type doneHook struct {
+	fn func(final Stat)
+}
+
+// removeDoneHook removes hook from the done hooks of p. The caller must hold
+// fnM.
+func (p *Progress) removeDoneHook(hook *doneHook) {
+	for i, h := range p.doneHooks {
+		if h == hook {
+			p.doneHooks = append(p.doneHooks[:i], p.doneHooks[i+1:]...)
+			return
+		}
+	}
+}
+
+// NewAggregator returns a new Aggregator which starts measuring time now. If
+// clock is nil, the system time is used.
+func NewAggregator(clock Clock) *Aggregator {
+	if clock == nil {
+		clock = realClock{}
+	}
+
+	return &Aggregator{clock: clock, start: clock.Now()}
+}
+
+// Add adds p to the aggregator. Only the statistics reported after p was
+// added are considered. Adding a child which was already added and is not
+// done yet does nothing. Add must not be called from a callback of p, for
+// example OnUpdate, as these run with the lock Add needs. A nil p is ignored.
+func (a *Aggregator) Add(p *Progress) {
+	if p == nil {
+		return
+	}
+
+	// the hook runs with fnM held, so lock in the same order here
+	p.fnM.Lock()
+	defer p.fnM.Unlock()
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	for _, c := range a.children {
+		if c.p == p {
+			return
+		}
+	}
+
+	child := &aggregatorChild{p: p, base: p.Current()}
+	child.hook = &doneHook{fn: func(final Stat) {
+		a.finish(child, final)
+	}}
+	a.children = append(a.children, child)
+	p.doneHooks = append(p.doneHooks, child.hook)
+}
+
+// finish freezes the contribution of child with its final statistics and
+// removes it from the list of running children. It also removes the done
+// hook, so a child which is reused is not counted again. It is called from
+// Done with fnM of the child held.
+func (a *Aggregator) finish(child *aggregatorChild, final Stat) {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	child.p.removeDoneHook(child.hook)
+	for i, c := range a.children {
+		if c == child {
+			a.done.Add(final.Sub(child.base))
+			a.children = append(a.children[:i], a.children[i+1:]...)
+			return
+		}
+	}
+}
+
+// Current returns the sum of the statistics reported by all children since
+// they were added, including the final statistics of children which are done.
+func (a *Aggregator) Current() Stat {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	s := a.done
+	for _, child := range a.children {
+		s.Add(child.p.Current().Sub(child.base))
+	}
+	return s
+}
+
+// CombinedRate returns the number of bytes per second processed by all
+// children together. It is defined as the bytes reported by all children
+// since they were added divided by the time since the aggregator was
+// created. Summing the average rates of the children instead would count the
+// time twice when the children run at the same time.
+func (a *Aggregator) CombinedRate() float64 {
+	sec := since(a.clock.Now(), a.start).Seconds()
+	if sec <= 0 {
+		return 0
+	}
+
+	return float64(a.Current().Bytes) / sec
+}
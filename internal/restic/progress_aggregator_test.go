@@ -0,0 +1,136 @@
+package restic
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAggregatorCombinedRate(t *testing.T) {
+	clock := newFakeClock()
+	agg := NewAggregator(clock)
+
+	first := NewProgress(WithClock(clock), WithoutTicker())
+	first.Start()
+	agg.Add(first)
+
+	second := NewProgress(WithClock(clock), WithoutTicker())
+
+	// the first child runs for 10 seconds at 100 bytes per second, the
+	// second one only for the last five seconds at 300 bytes per second, so
+	// both overlap in the second half
+	for i := 1; i <= 10; i++ {
+		if i == 6 {
+			second.Start()
+			agg.Add(second)
+		}
+
+		clock.Advance(time.Second)
+		first.Report(Stat{Bytes: 100})
+		want := uint64(100 * i)
+		if i > 5 {
+			second.Report(Stat{Bytes: 300})
+			want += uint64(300 * (i - 5))
+		}
+
+		if cur := agg.Current(); cur.Bytes != want {
+			t.Fatalf("after %ds: wrong combined bytes, want %v, got %v", i, want, cur.Bytes)
+		}
+		if rate, want := agg.CombinedRate(), float64(want)/float64(i); rate != want {
+			t.Errorf("after %ds: wrong combined rate, want %v, got %v", i, want, rate)
+		}
+	}
+	first.Done()
+	second.Done()
+
+	if cur := agg.Current(); cur.Bytes != 2500 {
+		t.Fatalf("wrong combined bytes %v", cur.Bytes)
+	}
+
+	// 2500 bytes in 10 seconds of aggregator time
+	if rate := agg.CombinedRate(); rate != 250 {
+		t.Errorf("wrong combined rate %v", rate)
+	}
+}
+
+func TestAggregatorIgnoresEarlierBytes(t *testing.T) {
+	clock := newFakeClock()
+
+	p := NewProgress(WithClock(clock), WithoutTicker())
+	p.Start()
+	defer p.Done()
+	p.Report(Stat{Bytes: 1000})
+
+	agg := NewAggregator(clock)
+	agg.Add(p)
+
+	clock.Advance(2 * time.Second)
+	p.Report(Stat{Bytes: 100})
+
+	if rate := agg.CombinedRate(); rate != 50 {
+		t.Errorf("wrong combined rate %v", rate)
+	}
+}
+
+func TestAggregatorFreezesDoneChild(t *testing.T) {
+	clock := newFakeClock()
+	agg := NewAggregator(clock)
+
+	child := NewProgress(WithClock(clock), WithoutTicker())
+	child.Start()
+	agg.Add(child)
+
+	child.Report(Stat{Files: 2, Bytes: 300})
+	if cur := agg.Current(); cur.Bytes != 300 {
+		t.Fatalf("live contribution missing, got %v", cur)
+	}
+
+	child.Done()
+	final := child.Current()
+
+	if cur := agg.Current(); cur != final {
+		t.Errorf("wrong total after Done, want %v, got %v", final, cur)
+	}
+
+	// the child is reused for something else, which must not be counted
+	child.Start()
+	child.Report(Stat{Bytes: 1000})
+	child.Done()
+
+	if cur := agg.Current(); cur != final {
+		t.Errorf("released child changed the total, want %v, got %v", final, cur)
+	}
+
+	agg.m.Lock()
+	n := len(agg.children)
+	agg.m.Unlock()
+	if n != 0 {
+		t.Errorf("done child still referenced, %d children left", n)
+	}
+
+	child.fnM.Lock()
+	n = len(child.doneHooks)
+	child.fnM.Unlock()
+	if n != 0 {
+		t.Errorf("done hook still registered, %d hooks left", n)
+	}
+}
+
+func TestAggregatorAddTwice(t *testing.T) {
+	clock := newFakeClock()
+	agg := NewAggregator(clock)
+
+	child := NewProgress(WithClock(clock), WithoutTicker())
+	child.Start()
+	agg.Add(child)
+	agg.Add(child)
+
+	child.Report(Stat{Files: 1, Bytes: 100})
+	if cur := agg.Current(); cur.Bytes != 100 {
+		t.Errorf("child counted twice while running, got %v", cur)
+	}
+
+	child.Done()
+	if cur := agg.Current(); cur.Bytes != 100 {
+		t.Errorf("child counted twice after Done, got %v", cur)
+	}
+}
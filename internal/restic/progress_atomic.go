@@ -0,0 +1,116 @@
+package restic
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// AtomicStat is a set of counters like Stat which can be updated from several
+// goroutines without a lock. The zero value is ready to use. Like Stat.Add,
+// counters which would overflow stay at math.MaxUint64.
+type AtomicStat struct {
+	files       atomic.Uint64
+	dirs        atomic.Uint64
+	bytes       atomic.Uint64
+	trees       atomic.Uint64
+	blobs       atomic.Uint64
+	errors      atomic.Uint64
+	storedBytes atomic.Uint64
+	skipped     atomic.Uint64
+
+	compressedBytes atomic.Uint64
+}
+
+// addSaturating adds n to v, a counter which would overflow is set to the
+// maximum. A wrapped value is never stored, so a concurrent Load does not
+// see one.
+func addSaturating(v *atomic.Uint64, n uint64) {
+	if n == 0 {
+		return
+	}
+
+	for {
+		old := v.Load()
+		sum := old + n
+		if sum < old {
+			sum = math.MaxUint64
+		}
+		if v.CompareAndSwap(old, sum) {
+			return
+		}
+	}
+}
+
+// Add adds all counters of s.
+func (a *AtomicStat) Add(s Stat) {
+	addSaturating(&a.files, s.Files)
+	addSaturating(&a.dirs, s.Dirs)
+	addSaturating(&a.bytes, s.Bytes)
+	addSaturating(&a.trees, s.Trees)
+	addSaturating(&a.blobs, s.Blobs)
+	addSaturating(&a.errors, s.Errors)
+	addSaturating(&a.storedBytes, s.StoredBytes)
+	addSaturating(&a.skipped, s.Skipped)
+	addSaturating(&a.compressedBytes, s.CompressedBytes)
+}
+
+// AddFiles adds n files.
+func (a *AtomicStat) AddFiles(n uint64) { addSaturating(&a.files, n) }
+
+// AddDirs adds n dirs.
+func (a *AtomicStat) AddDirs(n uint64) { addSaturating(&a.dirs, n) }
+
+// AddBytes adds n bytes.
+func (a *AtomicStat) AddBytes(n uint64) { addSaturating(&a.bytes, n) }
+
+// AddTrees adds n trees.
+func (a *AtomicStat) AddTrees(n uint64) { addSaturating(&a.trees, n) }
+
+// AddBlobs adds n blobs.
+func (a *AtomicStat) AddBlobs(n uint64) { addSaturating(&a.blobs, n) }
+
+// AddErrors adds n errors.
+func (a *AtomicStat) AddErrors(n uint64) { addSaturating(&a.errors, n) }
+
+// AddStoredBytes adds n stored bytes.
+func (a *AtomicStat) AddStoredBytes(n uint64) { addSaturating(&a.storedBytes, n) }
+
+// AddSkipped adds n skipped files.
+func (a *AtomicStat) AddSkipped(n uint64) { addSaturating(&a.skipped, n) }
+
+// AddCompressedBytes adds n compressed bytes.
+func (a *AtomicStat) AddCompressedBytes(n uint64) { addSaturating(&a.compressedBytes, n) }
+
+// take returns the current counters and sets them to zero. Like for Load, a
+// concurrent Add may be only partially included, the rest is kept.
+func (a *AtomicStat) take() Stat {
+	return Stat{
+		Files:       a.files.Swap(0),
+		Dirs:        a.dirs.Swap(0),
+		Bytes:       a.bytes.Swap(0),
+		Trees:       a.trees.Swap(0),
+		Blobs:       a.blobs.Swap(0),
+		Errors:      a.errors.Swap(0),
+		StoredBytes: a.storedBytes.Swap(0),
+		Skipped:     a.skipped.Swap(0),
+
+		CompressedBytes: a.compressedBytes.Swap(0),
+	}
+}
+
+// Load returns the current counters. Each counter is read atomically, but a
+// concurrent Add may be only partially included in the result.
+func (a *AtomicStat) Load() Stat {
+	return Stat{
+		Files:       a.files.Load(),
+		Dirs:        a.dirs.Load(),
+		Bytes:       a.bytes.Load(),
+		Trees:       a.trees.Load(),
+		Blobs:       a.blobs.Load(),
+		Errors:      a.errors.Load(),
+		StoredBytes: a.storedBytes.Load(),
+		Skipped:     a.skipped.Load(),
+
+		CompressedBytes: a.compressedBytes.Load(),
+	}
+}
@@ -0,0 +1,126 @@
+package restic
+
+import (
+	"math"
+	"sync"
+	"testing"
+)
+
+func TestAtomicStatConcurrent(t *testing.T) {
+	var a AtomicStat
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				a.AddFiles(1)
+				a.AddBytes(512)
+				a.Add(Stat{Dirs: 1, Blobs: 2})
+				_ = a.Load()
+			}
+		}()
+	}
+
+	// a reader observes counters which never go down
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var last Stat
+		for i := 0; i < 1000; i++ {
+			s := a.Load()
+			if s.Files < last.Files || s.Bytes < last.Bytes {
+				t.Errorf("counters went down from %v to %v", last, s)
+				return
+			}
+			last = s
+		}
+	}()
+
+	wg.Wait()
+	<-done
+
+	want := Stat{Files: 8000, Dirs: 8000, Bytes: 8000 * 512, Blobs: 16000}
+	if s := a.Load(); s != want {
+		t.Errorf("wrong counters, want %v, got %v", want, s)
+	}
+}
+
+func TestAtomicStatSaturatingConcurrent(t *testing.T) {
+	var a AtomicStat
+	start := uint64(math.MaxUint64 - 1000)
+	a.AddBytes(start)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				a.AddBytes(1 << 20)
+			}
+		}()
+	}
+
+	// a wrapped value is never visible, not even for a moment
+	for i := 0; i < 10000; i++ {
+		if b := a.Load().Bytes; b < start {
+			t.Fatalf("saw wrapped value %d", b)
+		}
+	}
+	wg.Wait()
+
+	if b := a.Load().Bytes; b != math.MaxUint64 {
+		t.Errorf("bytes not saturated: %d", b)
+	}
+}
+
+func TestAtomicStatAddAll(t *testing.T) {
+	var a AtomicStat
+	a.AddFiles(1)
+	a.AddDirs(2)
+	a.AddBytes(3)
+	a.AddTrees(4)
+	a.AddBlobs(5)
+	a.AddErrors(6)
+	a.AddStoredBytes(2)
+	a.AddSkipped(8)
+	a.AddCompressedBytes(3)
+
+	want := Stat{Files: 1, Dirs: 2, Bytes: 3, Trees: 4, Blobs: 5, Errors: 6, StoredBytes: 2, Skipped: 8, CompressedBytes: 3}
+	if s := a.Load(); s != want {
+		t.Errorf("wrong counters, want %v, got %v", want, s)
+	}
+
+	a.Add(Stat{Bytes: math.MaxUint64})
+	if s := a.Load(); s.Bytes != math.MaxUint64 {
+		t.Errorf("bytes not saturated: %d", s.Bytes)
+	}
+}
+
+func BenchmarkAtomicStatAdd(b *testing.B) {
+	var a AtomicStat
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			a.AddFiles(1)
+			a.AddBytes(512)
+		}
+	})
+}
+
+func BenchmarkAtomicStatLoad(b *testing.B) {
+	var a AtomicStat
+	a.Add(Stat{Files: 1, Bytes: 512})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_ = a.Load()
+	}
+}
@@ -0,0 +1,81 @@
+package restic
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Bar is a progress bar of another library, for example one rendering to a
+// terminal UI. Values are in bytes.
+type Bar interface {
+	SetTotal(total int64)
+	SetCurrent(current int64)
+	Finish()
+}
+
+// barAdapter passes the updates of a Progress on to a Bar.
+type barAdapter struct {
+	p   *Progress
+	bar Bar
+
+	m sync.Mutex
+	// total is the total passed to the bar last, negative if none was
+	total int64
+}
+
+// AttachBar wires the updates of p to bar. On each update, the bar is told
+// the bytes processed so far, and the total bytes when they changed. Done
+// sends a final update with the bytes of the whole run, then Finish is
+// called. Previously configured OnUpdate and OnDone functions are still
+// called. It must be called before Start(). A nil p is ignored.
+func AttachBar(p *Progress, bar Bar) {
+	if p == nil {
+		return
+	}
+
+	a := &barAdapter{p: p, bar: bar, total: -1}
+
+	onUpdate, onDone := p.OnUpdate, p.OnDone
+	p.OnUpdate = func(s Stat, d time.Duration, ticker bool) {
+		if onUpdate != nil {
+			onUpdate(s, d, ticker)
+		}
+		a.update(s)
+	}
+	p.OnDone = func(s Stat, d time.Duration, ticker bool) {
+		a.done()
+		if onDone != nil {
+			onDone(s, d, ticker)
+		}
+	}
+}
+
+func (a *barAdapter) update(s Stat) {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	if total := clampInt64(a.p.Total().Bytes); total != a.total {
+		a.bar.SetTotal(total)
+		a.total = total
+	}
+	a.bar.SetCurrent(clampInt64(s.Bytes))
+}
+
+// done finishes the bar, Done has already passed the final statistics to
+// update.
+func (a *barAdapter) done() {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	a.bar.Finish()
+	a.total = -1
+}
+
+// clampInt64 converts v to an int64, values which do not fit are clamped.
+func clampInt64(v uint64) int64 {
+	if v > math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(v)
+}
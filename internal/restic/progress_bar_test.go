@@ -0,0 +1,74 @@
+package restic
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+// fakeBar records the calls to a Bar.
+type fakeBar struct {
+	calls []string
+}
+
+func (b *fakeBar) SetTotal(total int64) {
+	b.calls = append(b.calls, fmt.Sprintf("total %d", total))
+}
+
+func (b *fakeBar) SetCurrent(current int64) {
+	b.calls = append(b.calls, fmt.Sprintf("current %d", current))
+}
+
+func (b *fakeBar) Finish() {
+	b.calls = append(b.calls, "finish")
+}
+
+func TestAttachBar(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+	p.SetTotal(Stat{Bytes: 1000})
+
+	updates := 0
+	p.OnUpdate = func(s Stat, d time.Duration, ticker bool) {
+		updates++
+	}
+
+	bar := &fakeBar{}
+	AttachBar(p, bar)
+
+	p.Start()
+	clock.Advance(time.Second)
+	p.Report(Stat{Bytes: 200})
+	clock.Advance(time.Second)
+	p.Report(Stat{Bytes: 300})
+
+	p.SetTotal(Stat{Bytes: 2000})
+	clock.Advance(time.Second)
+	p.Report(Stat{Bytes: 500})
+	p.Done()
+
+	want := []string{
+		"total 1000", "current 200",
+		"current 500",
+		"total 2000", "current 1000",
+		// the final update and Done
+		"current 1000", "finish",
+	}
+	if fmt.Sprint(bar.calls) != fmt.Sprint(want) {
+		t.Errorf("wrong calls to the bar, want %v, got %v", want, bar.calls)
+	}
+
+	if updates != 4 {
+		t.Errorf("previous OnUpdate called %d times, want 4", updates)
+	}
+}
+
+func TestClampInt64(t *testing.T) {
+	if v := clampInt64(42); v != 42 {
+		t.Errorf("wrong value %d", v)
+	}
+	if v := clampInt64(math.MaxUint64); v != math.MaxInt64 {
+		t.Errorf("value not clamped: %d", v)
+	}
+}
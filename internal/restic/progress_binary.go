@@ -0,0 +1,102 @@
+package restic
+
+import (
+	"encoding/binary"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// statBinaryVersion is the version of the binary encoding of a Stat.
+const statBinaryVersion = 1
+
+// binaryFields returns pointers to the counters of s in the order of the
+// binary encoding. New counters must only be appended.
+func (s *Stat) binaryFields() []*uint64 {
+	return []*uint64{
+		&s.Files,
+		&s.Dirs,
+		&s.Bytes,
+		&s.Trees,
+		&s.Blobs,
+		&s.Errors,
+		&s.StoredBytes,
+		&s.Skipped,
+		&s.CompressedBytes,
+	}
+}
+
+// statFieldNames are the names of the counters returned by binaryFields, in
+// the same order.
+var statFieldNames = []string{
+	"files",
+	"dirs",
+	"bytes",
+	"trees",
+	"blobs",
+	"errors",
+	"stored_bytes",
+	"skipped",
+	"compressed_bytes",
+}
+
+// StatCounter is a single counter of a Stat together with its name.
+type StatCounter struct {
+	Name  string
+	Value uint64
+}
+
+// Fields returns all counters of s by name, for example {"stored_bytes",
+// 1024}, in a fixed order. Exporters can iterate over them instead of listing
+// the fields, so that they include counters which are added later.
+func (s Stat) Fields() []StatCounter {
+	values := s.binaryFields()
+
+	fields := make([]StatCounter, len(values))
+	for i, v := range values {
+		fields[i] = StatCounter{Name: statFieldNames[i], Value: *v}
+	}
+	return fields
+}
+
+// MarshalBinary encodes s as a version byte, the number of counters as a
+// byte and then each counter as a little-endian uint64.
+func (s Stat) MarshalBinary() ([]byte, error) {
+	fields := s.binaryFields()
+
+	buf := make([]byte, 2+8*len(fields))
+	buf[0] = statBinaryVersion
+	buf[1] = byte(len(fields))
+	for i, f := range fields {
+		binary.LittleEndian.PutUint64(buf[2+8*i:], *f)
+	}
+
+	return buf, nil
+}
+
+// UnmarshalBinary decodes data written by MarshalBinary. Counters missing
+// from data are set to zero and unknown counters after the known ones are
+// ignored, so that different versions of restic can exchange stats.
+func (s *Stat) UnmarshalBinary(data []byte) error {
+	if len(data) < 2 {
+		return errors.Errorf("binary stat too short: %d bytes", len(data))
+	}
+
+	if data[0] != statBinaryVersion {
+		return errors.Errorf("unknown binary stat version %d", data[0])
+	}
+
+	n := int(data[1])
+	if len(data) != 2+8*n {
+		return errors.Errorf("binary stat has wrong length %d for %d counters", len(data), n)
+	}
+
+	*s = Stat{}
+	for i, f := range s.binaryFields() {
+		if i >= n {
+			break
+		}
+		*f = binary.LittleEndian.Uint64(data[2+8*i:])
+	}
+
+	return nil
+}
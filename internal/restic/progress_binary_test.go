@@ -0,0 +1,133 @@
+package restic
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+func TestStatBinaryRoundTrip(t *testing.T) {
+	var tests = []Stat{
+		{},
+		{Files: 1, Dirs: 2, Bytes: 3, Trees: 4, Blobs: 5, Errors: 6, StoredBytes: 7, Skipped: 8, CompressedBytes: 9},
+		{Bytes: 1<<64 - 1, Skipped: 1 << 63},
+	}
+
+	for _, s := range tests {
+		buf, err := s.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var s2 Stat
+		if err := s2.UnmarshalBinary(buf); err != nil {
+			t.Fatal(err)
+		}
+
+		if s != s2 {
+			t.Errorf("round trip failed, want %v, got %v", s, s2)
+		}
+	}
+}
+
+func TestStatUnmarshalBinaryCompat(t *testing.T) {
+	// an encoding with only the first two counters
+	buf := []byte{statBinaryVersion, 2, 3, 0, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0, 0, 0, 0}
+
+	s := Stat{Bytes: 100}
+	if err := s.UnmarshalBinary(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (Stat{Files: 3, Dirs: 4}); s != want {
+		t.Errorf("wrong stat, want %v, got %v", want, s)
+	}
+}
+
+func TestStatUnmarshalBinaryInvalid(t *testing.T) {
+	var tests = [][]byte{
+		nil,
+		{statBinaryVersion},
+		{statBinaryVersion + 1, 0},
+		{statBinaryVersion, 1, 0, 0},
+		{statBinaryVersion, 0, 0},
+	}
+
+	for _, buf := range tests {
+		var s Stat
+		if err := s.UnmarshalBinary(buf); err == nil {
+			t.Errorf("no error for invalid data %v", buf)
+		}
+	}
+}
+
+func FuzzStatUnmarshalBinary(f *testing.F) {
+	buf, _ := Stat{Files: 1, Bytes: 2, Skipped: 3}.MarshalBinary()
+	f.Add(buf)
+	f.Add([]byte{})
+	f.Add([]byte{statBinaryVersion, 255})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var s Stat
+		if err := s.UnmarshalBinary(data); err != nil {
+			return
+		}
+
+		// everything which decodes must encode to the known counters
+		buf, err := s.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var s2 Stat
+		if err := s2.UnmarshalBinary(buf); err != nil {
+			t.Fatal(err)
+		}
+		if s != s2 {
+			t.Fatalf("round trip failed, want %v, got %v", s, s2)
+		}
+	})
+}
+
+// snakeCase converts a Go field name like "StoredBytes" to "stored_bytes".
+func snakeCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func TestStatFields(t *testing.T) {
+	s := Stat{Files: 1, Dirs: 2, Bytes: 3, Trees: 4, Blobs: 5, Errors: 6,
+		StoredBytes: 7, Skipped: 8, CompressedBytes: 9}
+
+	fields := s.Fields()
+
+	// all counters of Stat are included, in the order they are declared
+	v := reflect.ValueOf(s)
+	if len(fields) != v.NumField() {
+		t.Fatalf("expected %d fields, got %d: %v", v.NumField(), len(fields), fields)
+	}
+
+	for i, f := range fields {
+		name := snakeCase(v.Type().Field(i).Name)
+		if f.Name != name {
+			t.Errorf("field %d: want name %q, got %q", i, name, f.Name)
+		}
+		// the JSON encoding uses the same names
+		if tag := v.Type().Field(i).Tag.Get("json"); tag != f.Name {
+			t.Errorf("field %v: wrong JSON name %q", f.Name, tag)
+		}
+		if want := v.Field(i).Uint(); f.Value != want {
+			t.Errorf("field %v: want value %d, got %d", f.Name, want, f.Value)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package restic
+
+import "time"
+
+// Clock is the source of time used by Progress. It can be replaced in tests
+// to make elapsed time and rate computations deterministic. Now should be
+// monotonic, durations computed from a Clock which jumps backwards are clamped
+// to zero.
+type Clock interface {
+	Now() time.Time
+}
+
+// MonotonicClock is a Clock which measures elapsed time itself, for example
+// on a monotonic counter which is not affected by changes of the wall clock.
+// If the Clock passed to WithClock implements it, Elapsed uses Since.
+type MonotonicClock interface {
+	Clock
+
+	// Since returns the time elapsed since t, which was returned by Now.
+	Since(t time.Time) time.Duration
+}
+
+// Ticker delivers ticks on a channel, like time.Ticker.
+type Ticker interface {
+	Chan() <-chan time.Time
+	Stop()
+}
+
+// TickerClock is a Clock which also controls the ticker of the reporter. If
+// the Clock passed to WithClock does not implement it, a time.Ticker is used.
+type TickerClock interface {
+	Clock
+	NewTicker(d time.Duration) Ticker
+}
+
+// realClock returns the current local time. The values returned by time.Now
+// carry a monotonic clock reading, so durations between them are not
+// affected by changes of the wall clock.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// Since uses the monotonic clock reading of t, if it has one.
+func (realClock) Since(t time.Time) time.Duration { return time.Since(t) }
+
+// realTicker is a Ticker backed by a time.Ticker.
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) Chan() <-chan time.Time { return t.C }
+
+// since returns the time from t to now. It is zero if now is before t, which
+// happens when the clock jumps backwards.
+func since(now, t time.Time) time.Duration {
+	d := now.Sub(t)
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// newTicker returns a ticker for clock which ticks every d.
+func newTicker(clock Clock, d time.Duration) Ticker {
+	if tc, ok := clock.(TickerClock); ok {
+		return tc.NewTicker(d)
+	}
+	return realTicker{time.NewTicker(d)}
+}
@@ -0,0 +1,86 @@
+package restic
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// csvHeader is the first row written by a ProgressCSV.
+var csvHeader = []string{"timestamp", "elapsed", "files", "dirs", "bytes", "rate"}
+
+// ProgressCSV writes samples of a Progress as CSV rows for later analysis.
+// After a header, a row is written for each ticker update and a final row
+// when the Progress is done. The rate column is the smoothed rate in bytes
+// per second.
+type ProgressCSV struct {
+	p   *Progress
+	w   *csv.Writer
+	m   sync.Mutex
+	err error
+}
+
+// NewProgressCSV attaches a CSV writer to p, previously configured OnUpdate
+// and OnDone functions are still called. It must be called before Start().
+// For a nil p, nothing is written.
+func NewProgressCSV(p *Progress, w io.Writer) *ProgressCSV {
+	c := &ProgressCSV{p: p, w: csv.NewWriter(w)}
+	if p == nil {
+		return c
+	}
+	c.write(csvHeader)
+
+	onUpdate, onDone := p.OnUpdate, p.OnDone
+	p.OnUpdate = func(s Stat, d time.Duration, ticker bool) {
+		if onUpdate != nil {
+			onUpdate(s, d, ticker)
+		}
+		if ticker {
+			c.writeStat(s, d)
+		}
+	}
+	p.OnDone = func(s Stat, d time.Duration, ticker bool) {
+		if onDone != nil {
+			onDone(s, d, ticker)
+		}
+		c.writeStat(s, d)
+	}
+
+	return c
+}
+
+func (c *ProgressCSV) writeStat(s Stat, d time.Duration) {
+	c.write([]string{
+		c.p.now().UTC().Format(time.RFC3339Nano),
+		strconv.FormatFloat(d.Seconds(), 'f', 3, 64),
+		strconv.FormatUint(s.Files, 10),
+		strconv.FormatUint(s.Dirs, 10),
+		strconv.FormatUint(s.Bytes, 10),
+		strconv.FormatFloat(c.p.SmoothedRate(), 'f', 3, 64),
+	})
+}
+
+func (c *ProgressCSV) write(row []string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.err != nil {
+		return
+	}
+
+	err := c.w.Write(row)
+	if err == nil {
+		c.w.Flush()
+		err = c.w.Error()
+	}
+	c.err = err
+}
+
+// Err returns the first error which occurred while writing.
+func (c *ProgressCSV) Err() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.err
+}
@@ -0,0 +1,53 @@
+package restic
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestProgressCSV(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+
+	buf := bytes.NewBuffer(nil)
+	c := NewProgressCSV(p, buf)
+
+	p.Start()
+	for i := 0; i < 3; i++ {
+		clock.Advance(time.Second)
+		p.Report(Stat{Files: 1, Bytes: 1000})
+		p.tick()
+	}
+	clock.Advance(500 * time.Millisecond)
+	p.Report(Stat{Dirs: 1})
+	p.Done()
+
+	if err := c.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// header, one row per tick and the final row
+	if len(rows) != 5 {
+		t.Fatalf("expected 5 rows, got %d: %v", len(rows), rows)
+	}
+
+	if !reflect.DeepEqual(rows[0], csvHeader) {
+		t.Errorf("wrong header %v", rows[0])
+	}
+
+	want := []string{
+		clock.Now().UTC().Format(time.RFC3339Nano),
+		"3.500", "3", "1", "3000", "1000.000",
+	}
+	if final := rows[len(rows)-1]; !reflect.DeepEqual(final, want) {
+		t.Errorf("wrong final row, want %v, got %v", want, final)
+	}
+}
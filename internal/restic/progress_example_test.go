@@ -0,0 +1,28 @@
+package restic_test
+
+import (
+	"fmt"
+
+	"github.com/restic/restic/internal/restic"
+)
+
+func ExampleStat_Add() {
+	p := restic.NewProgress(restic.WithoutTicker())
+	p.Start()
+
+	// accumulate locally and report in batches of 100 files
+	var batch restic.Stat
+	for i := 0; i < 1000; i++ {
+		batch.Add(restic.Stat{Files: 1, Bytes: 512})
+
+		if batch.Files == 100 {
+			p.Report(batch)
+			batch = restic.Stat{}
+		}
+	}
+	p.Report(batch)
+	p.Done()
+
+	fmt.Println(p.Current())
+	// Output: Stat(1000 files, 0 dirs, 0 trees, 0 blobs, 0 errors, 500.0 KiB)
+}
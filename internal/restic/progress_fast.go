@@ -0,0 +1,65 @@
+package restic
+
+import "time"
+
+// ReportFast reports s like Report, but is cheaper for workloads with many
+// tiny reports, for example one per small file. Between the throttled updates
+// of Report, s is only added to atomic counters without taking a lock or
+// running any callbacks. Those reports are coalesced into the next update,
+// whether it is triggered by Report, a tick, Flush or Done, and are included
+// in Current and Snapshot right away. Other statistics like the rate or the
+// time of the last activity may lag behind by up to one throttle interval.
+//
+// The fast path is not used while milestones are registered or until the
+// first data has been reported to OnFirstData, so these are never missed.
+func (p *Progress) ReportFast(s Stat) {
+	if p == nil {
+		return
+	}
+
+	if !p.running {
+		panic("reporting in a non-running Progress")
+	}
+
+	if until := p.fastUntil.Load(); until != 0 {
+		now := p.now().UnixNano()
+		// a clock which jumped back before the window must not keep it open
+		if now < until && now >= until-int64(minTickerTime) {
+			p.fast.Add(s)
+			return
+		}
+	}
+
+	p.Report(s)
+}
+
+// openFastWindow lets ReportFast use the fast path until the next update from
+// Report is due, or for one throttle interval at now if Report does not
+// deliver updates. The caller must hold curM.
+func (p *Progress) openFastWindow(now time.Time) {
+	if len(p.milestones) > 0 || (!p.seenData && p.OnFirstData != nil) {
+		p.fastUntil.Store(0)
+		return
+	}
+
+	start := now
+	if p.updateOnReport {
+		start = p.lastUpdate
+	}
+	p.fastUntil.Store(start.Add(minTickerTime).UnixNano())
+}
+
+// curLocked returns the current statistics including the reports from the
+// fast path of ReportFast. The caller must hold curM.
+func (p *Progress) curLocked() Stat {
+	p.foldFast()
+	return p.cur
+}
+
+// foldFast adds the reports from the fast path of ReportFast to the current
+// statistics. The caller must hold curM.
+func (p *Progress) foldFast() {
+	s := p.fast.take()
+	p.cur.Add(s)
+	p.lifetime.Add(s)
+}
@@ -0,0 +1,198 @@
+package restic
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProgressReportFastCoalesced(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+
+	var updates []Stat
+	p.OnUpdate = func(s Stat, d time.Duration, ticker bool) {
+		updates = append(updates, s)
+	}
+	var final Stat
+	p.OnDone = func(s Stat, d time.Duration, ticker bool) {
+		final = s
+	}
+
+	p.Start()
+	clock.Advance(time.Second)
+
+	// the first report opens the window, the others are coalesced
+	for i := 0; i < 100; i++ {
+		p.ReportFast(Stat{Files: 1, Bytes: 10})
+	}
+	if len(updates) != 1 || updates[0].Files != 1 {
+		t.Fatalf("expected a single update for the first report, got %v", updates)
+	}
+	if s := p.Current(); s.Files != 100 || s.Bytes != 1000 {
+		t.Errorf("coalesced reports missing from Current: %v", s)
+	}
+
+	// once the throttle interval has passed, the next report delivers all
+	clock.Advance(time.Second)
+	p.ReportFast(Stat{Files: 1, Bytes: 10})
+	if len(updates) != 2 || updates[1].Files != 101 || updates[1].Bytes != 1010 {
+		t.Fatalf("wrong update after the throttle interval: %v", updates)
+	}
+
+	for i := 0; i < 10; i++ {
+		p.ReportFast(Stat{Dirs: 1})
+	}
+	p.Done()
+
+	want := Stat{Files: 101, Dirs: 10, Bytes: 1010}
+	if final != want {
+		t.Errorf("wrong final statistics, want %v, got %v", want, final)
+	}
+	if s := p.LifetimeStat(); s != want {
+		t.Errorf("wrong lifetime statistics, want %v, got %v", want, s)
+	}
+}
+
+func TestProgressReportFastAccessors(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+	p.SetTotal(Stat{Files: 100, Bytes: 1000})
+	p.Start()
+	defer p.Done()
+
+	clock.Advance(10 * time.Second)
+	for i := 0; i < 50; i++ {
+		p.ReportFast(Stat{Files: 1, Bytes: 10})
+	}
+
+	// all accessors see the coalesced reports without a call to Current
+	var tests = []struct {
+		name string
+		get  func() float64
+		want float64
+	}{
+		{"PercentDone", p.PercentDone, 50},
+		{"ItemsPercent", p.ItemsPercent, 50},
+		{"AverageRate", p.AverageRate, 50},
+		{"ActiveRate", p.ActiveRate, 50},
+		{"ItemRate", p.ItemRate, 5},
+		{"ItemsPerSecond", p.ItemsPerSecond, 5},
+		{"ETA", func() float64 { return p.ETA().Seconds() }, 10},
+		{"RemainingBytes", func() float64 { n, _ := p.RemainingBytes(); return float64(n) }, 500},
+		{"RemainingItems", func() float64 { n, _ := p.RemainingItems(); return float64(n) }, 50},
+		{"Items", func() float64 { return float64(p.Items()) }, 50},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.get(); got != test.want {
+				t.Errorf("want %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestProgressReportFastTick(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithManualTick())
+
+	var last Stat
+	p.OnUpdate = func(s Stat, d time.Duration, ticker bool) {
+		last = s
+	}
+
+	p.Start()
+	for i := 0; i < 50; i++ {
+		p.ReportFast(Stat{Files: 1})
+	}
+
+	clock.Advance(time.Second)
+	p.Tick()
+	if last.Files != 50 {
+		t.Errorf("tick did not include the coalesced reports: %v", last)
+	}
+	p.Done()
+}
+
+func TestProgressReportFastMilestones(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+
+	var firstData int
+	p.OnFirstData = func() { firstData++ }
+
+	var reached []uint64
+	p.Start()
+	p.ReportFast(Stat{Files: 1})
+	p.ReportFast(Stat{Files: 1})
+	p.RegisterMilestone(FieldFiles, 5, func(n uint64, cur Stat) {
+		reached = append(reached, n)
+	})
+
+	for i := 0; i < 10; i++ {
+		p.ReportFast(Stat{Files: 1, Bytes: 1})
+	}
+	p.Done()
+
+	if firstData != 1 {
+		t.Errorf("OnFirstData called %d times, want once", firstData)
+	}
+	if len(reached) != 2 || reached[0] != 5 || reached[1] != 10 {
+		t.Errorf("wrong milestones reached: %v", reached)
+	}
+	if s := p.Current(); s.Files != 12 {
+		t.Errorf("wrong number of files %d", s.Files)
+	}
+}
+
+func TestProgressReportFastConcurrent(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+	p.Start()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				p.ReportFast(Stat{Files: 1, Bytes: 512})
+			}
+		}()
+	}
+	wg.Wait()
+	p.Done()
+
+	if s, _ := p.Wait(); s.Files != 8000 || s.Bytes != 8000*512 {
+		t.Errorf("reports lost: %v", s)
+	}
+}
+
+func BenchmarkProgressReportFast(b *testing.B) {
+	p := NewProgress(WithoutTicker())
+	p.Start()
+	defer p.Done()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		p.ReportFast(Stat{Files: 1, Bytes: 512})
+	}
+}
+
+func TestProgressReportFastRacingDone(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+	p.Start()
+	p.Done()
+
+	// a ReportFast which passed the running check before Done adds to the
+	// fast counters after the run has ended
+	p.fast.Add(Stat{Files: 1})
+
+	p.Start()
+	defer p.Done()
+	if s := p.Current(); s != (Stat{}) {
+		t.Errorf("report of the previous run leaked into the next one: %v", s)
+	}
+}
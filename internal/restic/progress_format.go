@@ -0,0 +1,323 @@
+package restic
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scaleBytes returns c scaled to the largest binary unit in which it is
+// greater than one, together with the name of the unit.
+func scaleBytes(c uint64) (float64, string) {
+	b := float64(c)
+
+	switch {
+	case c > 1<<40:
+		return b / (1 << 40), "TiB"
+	case c > 1<<30:
+		return b / (1 << 30), "GiB"
+	case c > 1<<20:
+		return b / (1 << 20), "MiB"
+	case c > 1<<10:
+		return b / (1 << 10), "KiB"
+	default:
+		return b, "B"
+	}
+}
+
+// defaultPrecision is the number of decimals used for each unit if a
+// ByteFormat does not configure it. Larger units get more decimals, as each
+// of them stands for more bytes. The decimal units used by FormatRate get the
+// same number as the binary unit of the same tier.
+var defaultPrecision = map[string]int{
+	"B":   0,
+	"KiB": 1,
+	"MiB": 2,
+	"GiB": 3,
+	"TiB": 3,
+	"kB":  1,
+	"MB":  2,
+	"GB":  3,
+	"TB":  3,
+}
+
+// ByteFormat configures how FormatBytes formats a number of bytes.
+type ByteFormat struct {
+	// ShowExact appends the exact number of bytes to values of one KiB or
+	// more, for example "4.200 GiB (4509715660 bytes)".
+	ShowExact bool
+
+	// Precision maps a unit like "KiB" or "kB" to the number of decimals
+	// shown for it. Units which are not included use the default of no
+	// decimals for B, one for KiB and kB, two for MiB and MB and three for
+	// the larger units. Negative values are treated as zero.
+	Precision map[string]int
+}
+
+// precision returns the number of decimals for unit.
+func (f ByteFormat) precision(unit string) int {
+	prec, ok := f.Precision[unit]
+	if !ok {
+		prec = defaultPrecision[unit]
+	}
+	if prec < 0 {
+		prec = 0
+	}
+	return prec
+}
+
+// DefaultByteFormat is the format used by FormatBytes and Stat.String.
+var DefaultByteFormat = ByteFormat{}
+
+// Format returns c in the largest binary unit in which it is greater than
+// one, with the number of decimals configured for the unit, for example
+// "4.200 GiB" or "1.5 KiB".
+func (f ByteFormat) Format(c uint64) string {
+	var buf [48]byte
+	return string(f.appendFormat(buf[:0], c))
+}
+
+// appendFormat appends c formatted like Format to b.
+func (f ByteFormat) appendFormat(b []byte, c uint64) []byte {
+	v, unit := scaleBytes(c)
+	prec := f.precision(unit)
+	if unit == "B" {
+		if prec == 0 {
+			b = strconv.AppendUint(b, c, 10)
+		} else {
+			b = strconv.AppendFloat(b, v, 'f', prec, 64)
+		}
+		return append(b, " B"...)
+	}
+
+	b = strconv.AppendFloat(b, v, 'f', prec, 64)
+	b = append(b, ' ')
+	b = append(b, unit...)
+	if f.ShowExact {
+		b = append(b, " ("...)
+		b = strconv.AppendUint(b, c, 10)
+		b = append(b, " bytes)"...)
+	}
+	return b
+}
+
+// FormatBytes formats c using DefaultByteFormat.
+func FormatBytes(c uint64) string {
+	return DefaultByteFormat.Format(c)
+}
+
+// scaleBytesSI is like scaleBytes, but uses decimal units.
+func scaleBytesSI(c uint64) (float64, string) {
+	b := float64(c)
+
+	switch {
+	case c > 1e12:
+		return b / 1e12, "TB"
+	case c > 1e9:
+		return b / 1e9, "GB"
+	case c > 1e6:
+		return b / 1e6, "MB"
+	case c > 1e3:
+		return b / 1e3, "kB"
+	default:
+		return b, "B"
+	}
+}
+
+// FormatRate formats a rate in bytes per second like FormatBytes, followed by
+// "/s". If si is set, decimal units like "MB/s" are used instead of binary
+// units like "MiB/s", with the same number of decimals for each tier. Negative
+// and NaN rates are shown as zero, rates which do not fit into an uint64 are
+// clamped.
+func FormatRate(bytesPerSec float64, si bool) string {
+	var c uint64
+	switch {
+	case !(bytesPerSec > 0):
+		c = 0
+	case bytesPerSec >= math.MaxUint64:
+		c = math.MaxUint64
+	default:
+		c = uint64(math.Round(bytesPerSec))
+	}
+
+	if !si {
+		return FormatBytes(c) + "/s"
+	}
+
+	v, unit := scaleBytesSI(c)
+	if unit == "B" {
+		return fmt.Sprintf("%d B/s", c)
+	}
+	return strconv.FormatFloat(v, 'f', DefaultByteFormat.precision(unit), 64) + " " + unit + "/s"
+}
+
+// alignedUnits are the units used by FormatBytesAligned.
+var alignedUnits = []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+
+// FormatBytesAligned formats c as an integer in the largest binary unit in
+// which it is at least one, rounded down. The result always has the same
+// width of eight characters, so it can be used for columns in tables, for
+// example " 512 B  " or "   4 GiB".
+func FormatBytesAligned(c uint64) string {
+	unit := 0
+	for c >= 1<<10 && unit < len(alignedUnits)-1 {
+		c >>= 10
+		unit++
+	}
+
+	return fmt.Sprintf("%4d %-3s", c, alignedUnits[unit])
+}
+
+// Compact returns a terse representation of s suitable as a log prefix, for
+// example "3f/2d/1.0GiB". The number of files and dirs and the bytes are
+// always included, trees, blobs and errors only when they are not zero. The
+// fields are always in the order files, dirs, trees, blobs, errors, bytes.
+func (s Stat) Compact() string {
+	parts := []string{
+		fmt.Sprintf("%df", s.Files),
+		fmt.Sprintf("%dd", s.Dirs),
+	}
+
+	if s.Trees != 0 {
+		parts = append(parts, fmt.Sprintf("%dt", s.Trees))
+	}
+	if s.Blobs != 0 {
+		parts = append(parts, fmt.Sprintf("%db", s.Blobs))
+	}
+	if s.Errors != 0 {
+		parts = append(parts, fmt.Sprintf("%de", s.Errors))
+	}
+
+	if v, unit := scaleBytes(s.Bytes); unit == "B" {
+		parts = append(parts, fmt.Sprintf("%dB", s.Bytes))
+	} else {
+		parts = append(parts, fmt.Sprintf("%.1f%s", v, unit))
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// pluralize returns n followed by noun, with an "s" appended to noun unless n
+// is one.
+func pluralize(n uint64, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// formatDuration formats d as [h:]mm:ss.
+func formatDuration(d time.Duration) string {
+	sec := uint64(d / time.Second)
+	hours := sec / 3600
+	sec -= hours * 3600
+	min := sec / 60
+	sec -= min * 60
+	if hours > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", hours, min, sec)
+	}
+
+	return fmt.Sprintf("%d:%02d", min, sec)
+}
+
+// summary returns a human-readable description of s processed in d.
+func summary(s Stat, d time.Duration) string {
+	parts := []string{
+		pluralize(s.Files, "file"),
+		pluralize(s.Dirs, "dir"),
+	}
+
+	if s.Trees != 0 {
+		parts = append(parts, pluralize(s.Trees, "tree"))
+	}
+	if s.Blobs != 0 {
+		parts = append(parts, pluralize(s.Blobs, "blob"))
+	}
+	if s.Errors != 0 {
+		parts = append(parts, pluralize(s.Errors, "error"))
+	}
+
+	parts = append(parts, FormatBytes(s.Bytes))
+
+	var rate float64
+	if d > 0 {
+		rate = float64(s.Bytes) / d.Seconds()
+	}
+
+	str := strings.Join(parts, ", ") + " in " + formatDuration(d) + " (" + FormatRate(rate, false) + ")"
+	if s.StoredBytes != 0 {
+		str += ", " + s.SavingsString()
+	}
+	return str
+}
+
+// countSuffixes are the suffixes used by abbreviateCount for each power of
+// 1000.
+var countSuffixes = []string{"", "k", "M", "B", "T", "Q"}
+
+// abbreviateCount formats n with one decimal and a suffix for thousands,
+// millions and so on, for example "1.2M". Values below 1000 are shown as is.
+func abbreviateCount(n uint64) string {
+	if n < 1000 {
+		return fmt.Sprintf("%d", n)
+	}
+
+	v := float64(n)
+	unit := 0
+	// also move up when rounding to one decimal would show 1000.0
+	for v >= 999.95 && unit < len(countSuffixes)-1 {
+		v /= 1000
+		unit++
+	}
+
+	return fmt.Sprintf("%.1f%s", v, countSuffixes[unit])
+}
+
+// StringAbbrev is like String, but abbreviates large counts, for example
+// "Stat(1.2M files, 3.4k dirs, 0 trees, 3.4B blobs, 0 errors, 1.000 TiB)".
+func (s Stat) StringAbbrev() string {
+	str := fmt.Sprintf("Stat(%v files, %v dirs, %v trees, %v blobs, %v errors, %v",
+		abbreviateCount(s.Files), abbreviateCount(s.Dirs), abbreviateCount(s.Trees),
+		abbreviateCount(s.Blobs), abbreviateCount(s.Errors), FormatBytes(s.Bytes))
+	if s.StoredBytes != 0 {
+		str += fmt.Sprintf(", %v stored", FormatBytes(s.StoredBytes))
+	}
+	if s.CompressedBytes != 0 {
+		str += fmt.Sprintf(", %v compressed", FormatBytes(s.CompressedBytes))
+	}
+	if s.Skipped != 0 {
+		str += fmt.Sprintf(", %v skipped", abbreviateCount(s.Skipped))
+	}
+	return str + ")"
+}
+
+// SavingsString describes how much deduplication and compression saved, for
+// example "saved 78% (3.200 GiB)".
+func (s Stat) SavingsString() string {
+	var saved uint64
+	if s.StoredBytes < s.Bytes {
+		saved = s.Bytes - s.StoredBytes
+	}
+	return fmt.Sprintf("saved %.0f%% (%v)", s.SavingsRatio()*100, FormatBytes(saved))
+}
+
+// Summary returns a human-readable description of what has been processed so
+// far, for example "1 file, 2 dirs, 3.00 MiB in 0:05 (614.4 KiB/s)".
+// Trees, blobs and errors are only included when they are not zero, the
+// savings only when stored bytes were reported and the maximum depth only
+// when ReportDepth was called. The runtime is taken from the clock of p, so
+// the result is deterministic with a fake clock passed to WithClock.
+func (p *Progress) Summary() string {
+	if p == nil {
+		return ""
+	}
+
+	snap := p.Snapshot()
+	str := summary(snap.Stat, snap.Runtime)
+	if snap.MaxDepth > 0 {
+		str += fmt.Sprintf(", max depth %d", snap.MaxDepth)
+	}
+	return str
+}
@@ -0,0 +1,432 @@
+package restic
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestStatCompact(t *testing.T) {
+	var tests = []struct {
+		s    Stat
+		want string
+	}{
+		{Stat{}, "0f/0d/0B"},
+		{Stat{Files: 3, Dirs: 2, Bytes: 1<<30 + 1<<20}, "3f/2d/1.0GiB"},
+		{Stat{Files: 1, Bytes: 512}, "1f/0d/512B"},
+		{Stat{Trees: 4, Blobs: 12, Bytes: 3 << 20}, "0f/0d/4t/12b/3.0MiB"},
+		{Stat{Blobs: 1, Errors: 2, Bytes: 1536}, "0f/0d/1b/2e/1.5KiB"},
+	}
+
+	for _, test := range tests {
+		t.Run("", func(t *testing.T) {
+			got := test.s.Compact()
+			if got != test.want {
+				t.Errorf("wrong compact representation for %v, want %q, got %q", test.s, test.want, got)
+			}
+		})
+	}
+}
+
+func TestPluralize(t *testing.T) {
+	var tests = []struct {
+		n    uint64
+		noun string
+		want string
+	}{
+		{0, "file", "0 files"},
+		{1, "file", "1 file"},
+		{2, "file", "2 files"},
+		{1, "dir", "1 dir"},
+		{3, "dir", "3 dirs"},
+		{1, "tree", "1 tree"},
+		{4, "tree", "4 trees"},
+		{1, "blob", "1 blob"},
+		{5, "blob", "5 blobs"},
+		{1, "error", "1 error"},
+		{6, "error", "6 errors"},
+	}
+
+	for _, test := range tests {
+		if got := pluralize(test.n, test.noun); got != test.want {
+			t.Errorf("pluralize(%d, %q): want %q, got %q", test.n, test.noun, test.want, got)
+		}
+	}
+}
+
+func TestSummary(t *testing.T) {
+	var tests = []struct {
+		s    Stat
+		d    time.Duration
+		want string
+	}{
+		{Stat{}, 0, "0 files, 0 dirs, 0 B in 0:00 (0 B/s)"},
+		{Stat{Files: 1, Dirs: 2, Bytes: 3 << 20}, 5 * time.Second, "1 file, 2 dirs, 3.00 MiB in 0:05 (614.4 KiB/s)"},
+		{Stat{Files: 2, Dirs: 1, Trees: 1, Blobs: 1, Errors: 1, Bytes: 130}, 65 * time.Second, "2 files, 1 dir, 1 tree, 1 blob, 1 error, 130 B in 1:05 (2 B/s)"},
+		{Stat{Trees: 2, Blobs: 3, Errors: 4}, 2 * time.Hour, "0 files, 0 dirs, 2 trees, 3 blobs, 4 errors, 0 B in 2:00:00 (0 B/s)"},
+	}
+
+	for _, test := range tests {
+		if got := summary(test.s, test.d); got != test.want {
+			t.Errorf("wrong summary for %v, want %q, got %q", test.s, test.want, got)
+		}
+	}
+}
+
+func TestAbbreviateCount(t *testing.T) {
+	var tests = []struct {
+		n    uint64
+		want string
+	}{
+		{0, "0"},
+		{999, "999"},
+		{1000, "1.0k"},
+		{1234, "1.2k"},
+		{999949, "999.9k"},
+		{999950, "1.0M"},
+		{1200000, "1.2M"},
+		{3400000000, "3.4B"},
+		{5e12, "5.0T"},
+		{math.MaxUint64, "18446.7Q"},
+	}
+
+	for _, test := range tests {
+		if got := abbreviateCount(test.n); got != test.want {
+			t.Errorf("abbreviateCount(%d): want %q, got %q", test.n, test.want, got)
+		}
+	}
+}
+
+func TestStatStringAbbrev(t *testing.T) {
+	s := Stat{Files: 1200000, Dirs: 3400, Blobs: 3400000000, Bytes: 1 << 20, Skipped: 2500}
+	want := "Stat(1.2M files, 3.4k dirs, 0 trees, 3.4B blobs, 0 errors, 1024.0 KiB, 2.5k skipped)"
+	if got := s.StringAbbrev(); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestStatSavings(t *testing.T) {
+	var tests = []struct {
+		s     Stat
+		ratio float64
+		str   string
+	}{
+		{Stat{Bytes: 1000, StoredBytes: 220}, 0.78, "saved 78% (780 B)"},
+		{Stat{Bytes: 4 << 30, StoredBytes: 1 << 30}, 0.75, "saved 75% (3.000 GiB)"},
+		{Stat{}, 0, "saved 0% (0 B)"},
+		{Stat{StoredBytes: 100}, 0, "saved 0% (0 B)"},
+		{Stat{Bytes: 100, StoredBytes: 300}, 0, "saved 0% (0 B)"},
+	}
+
+	for _, test := range tests {
+		if r := test.s.SavingsRatio(); r != test.ratio {
+			t.Errorf("SavingsRatio() for %v: want %v, got %v", test.s, test.ratio, r)
+		}
+		if str := test.s.SavingsString(); str != test.str {
+			t.Errorf("SavingsString() for %v: want %q, got %q", test.s, test.str, str)
+		}
+	}
+
+	want := "0 files, 0 dirs, 1000 B in 0:10 (100 B/s), saved 78% (780 B)"
+	if got := summary(Stat{Bytes: 1000, StoredBytes: 220}, 10*time.Second); got != want {
+		t.Errorf("wrong summary, want %q, got %q", want, got)
+	}
+}
+
+func TestByteFormatShowExact(t *testing.T) {
+	var tests = []struct {
+		c       uint64
+		off, on string
+	}{
+		{0, "0 B", "0 B"},
+		{512, "512 B", "512 B"},
+		{1536, "1.5 KiB", "1.5 KiB (1536 bytes)"},
+		{4509715660, "4.200 GiB", "4.200 GiB (4509715660 bytes)"},
+	}
+
+	for _, test := range tests {
+		if got := FormatBytes(test.c); got != test.off {
+			t.Errorf("FormatBytes(%d): want %q, got %q", test.c, test.off, got)
+		}
+
+		if got := (ByteFormat{ShowExact: false}).Format(test.c); got != test.off {
+			t.Errorf("Format(%d) with ShowExact off: want %q, got %q", test.c, test.off, got)
+		}
+
+		if got := (ByteFormat{ShowExact: true}).Format(test.c); got != test.on {
+			t.Errorf("Format(%d) with ShowExact on: want %q, got %q", test.c, test.on, got)
+		}
+	}
+}
+
+func TestFormatBytesAligned(t *testing.T) {
+	var tests = []struct {
+		c    uint64
+		want string
+	}{
+		{0, "   0 B  "},
+		{1, "   1 B  "},
+		{1023, "1023 B  "},
+		{1024, "   1 KiB"},
+		{1536, "   1 KiB"},
+		{1<<20 - 1, "1023 KiB"},
+		{5 << 20, "   5 MiB"},
+		{4509715660, "   4 GiB"},
+		{3 << 40, "   3 TiB"},
+		{7 << 50, "   7 PiB"},
+		{math.MaxUint64, "  15 EiB"},
+	}
+
+	for _, test := range tests {
+		got := FormatBytesAligned(test.c)
+		if got != test.want {
+			t.Errorf("FormatBytesAligned(%d): want %q, got %q", test.c, test.want, got)
+		}
+
+		if len(got) != 8 {
+			t.Errorf("FormatBytesAligned(%d): wrong width %d", test.c, len(got))
+		}
+	}
+}
+
+func TestFormatRate(t *testing.T) {
+	var tests = []struct {
+		rate float64
+		want string
+	}{
+		{0, "0 B/s"},
+		{-5, "0 B/s"},
+		{math.NaN(), "0 B/s"},
+		{512.4, "512 B/s"},
+		{1536, "1.5 KiB/s"},
+		{25 << 20, "25.00 MiB/s"},
+		{3 << 40, "3.000 TiB/s"},
+		{math.Inf(1), "16777216.000 TiB/s"},
+		{1e30, "16777216.000 TiB/s"},
+	}
+
+	for _, test := range tests {
+		if got := FormatRate(test.rate, false); got != test.want {
+			t.Errorf("FormatRate(%v): want %q, got %q", test.rate, test.want, got)
+		}
+	}
+}
+
+func TestFormatRateSI(t *testing.T) {
+	var tests = []struct {
+		rate float64
+		want string
+	}{
+		{0, "0 B/s"},
+		{0.4, "0 B/s"},
+		{999, "999 B/s"},
+		{1500, "1.5 kB/s"},
+		{25e6, "25.00 MB/s"},
+		{4.2e9, "4.200 GB/s"},
+		{3e12, "3.000 TB/s"},
+	}
+
+	for _, test := range tests {
+		if got := FormatRate(test.rate, true); got != test.want {
+			t.Errorf("FormatRate(%v, true): want %q, got %q", test.rate, test.want, got)
+		}
+	}
+}
+
+func TestByteFormatPrecision(t *testing.T) {
+	var tests = []struct {
+		c    uint64
+		want string
+	}{
+		{512, "512 B"},
+		{1536, "1.5 KiB"},
+		{1000 << 10, "1000.0 KiB"},
+		{3 << 19, "1.50 MiB"},
+		{4509715660, "4.200 GiB"},
+		{5<<40 + 1<<39, "5.500 TiB"},
+	}
+
+	for _, test := range tests {
+		if got := FormatBytes(test.c); got != test.want {
+			t.Errorf("FormatBytes(%d): want %q, got %q", test.c, test.want, got)
+		}
+	}
+
+	f := ByteFormat{Precision: map[string]int{"B": 1, "KiB": 0, "GiB": 1, "TiB": -1}}
+	tests = []struct {
+		c    uint64
+		want string
+	}{
+		{512, "512.0 B"},
+		{1536, "2 KiB"},
+		// not configured, the default is used
+		{3 << 19, "1.50 MiB"},
+		{4509715660, "4.2 GiB"},
+		{5<<40 + 1<<39, "6 TiB"},
+	}
+
+	for _, test := range tests {
+		if got := f.Format(test.c); got != test.want {
+			t.Errorf("Format(%d): want %q, got %q", test.c, test.want, got)
+		}
+	}
+}
+
+func TestFormatBytesSmall(t *testing.T) {
+	var tests = []struct {
+		c    uint64
+		want string
+	}{
+		{0, "0 B"},
+		{1, "1 B"},
+		{1023, "1023 B"},
+		{2048, "2.0 KiB"},
+	}
+
+	for _, test := range tests {
+		if got := FormatBytes(test.c); got != test.want {
+			t.Errorf("FormatBytes(%d): want %q, got %q", test.c, test.want, got)
+		}
+
+		want := "Stat(0 files, 0 dirs, 0 trees, 0 blobs, 0 errors, " + test.want + ")"
+		if got := (Stat{Bytes: test.c}).String(); got != want {
+			t.Errorf("String() for %d bytes: want %q, got %q", test.c, want, got)
+		}
+	}
+}
+
+func TestProgressSummaryGolden(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+	p.Start()
+
+	for i := 0; i < 5; i++ {
+		clock.Advance(13 * time.Second)
+		p.Report(Stat{Files: 3, Dirs: 1, Bytes: 3 << 20})
+	}
+	p.ReportError(nil)
+	p.Done()
+
+	want := "15 files, 5 dirs, 1 error, 15.00 MiB in 1:05 (236.3 KiB/s)"
+	if got := p.Summary(); got != want {
+		t.Errorf("wrong summary, want %q, got %q", want, got)
+	}
+}
+
+func TestProgressSummaryMaxDepth(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+	p.Start()
+
+	clock.Advance(5 * time.Second)
+	p.Report(Stat{Files: 1, Dirs: 2, Bytes: 3 << 20})
+	p.ReportDepth(4)
+	p.ReportDepth(2)
+	p.Done()
+
+	want := "1 file, 2 dirs, 3.00 MiB in 0:05 (614.4 KiB/s), max depth 4"
+	if got := p.Summary(); got != want {
+		t.Errorf("wrong summary, want %q, got %q", want, got)
+	}
+}
+
+// statStringFmt formats s like Stat.String using fmt, it is the baseline for
+// BenchmarkStatString.
+func statStringFmt(s Stat) string {
+	format := func(c uint64) string {
+		v, unit := scaleBytes(c)
+		if unit == "B" {
+			return fmt.Sprintf("%d B", c)
+		}
+		return fmt.Sprintf("%.*f %s", defaultPrecision[unit], v, unit)
+	}
+
+	str := fmt.Sprintf("Stat(%d files, %d dirs, %v trees, %v blobs, %d errors, %v",
+		s.Files, s.Dirs, s.Trees, s.Blobs, s.Errors, format(s.Bytes))
+	if s.StoredBytes != 0 {
+		str += fmt.Sprintf(", %v stored", format(s.StoredBytes))
+	}
+	if s.Skipped != 0 {
+		str += fmt.Sprintf(", %d skipped", s.Skipped)
+	}
+	return str + ")"
+}
+
+func TestStatStringGolden(t *testing.T) {
+	max := Stat{Files: math.MaxUint64, Dirs: math.MaxUint64, Trees: math.MaxUint64, Blobs: math.MaxUint64,
+		Errors: math.MaxUint64, Bytes: math.MaxUint64, StoredBytes: math.MaxUint64, Skipped: math.MaxUint64,
+		CompressedBytes: math.MaxUint64}
+
+	var tests = []struct {
+		s        Stat
+		want     string
+		combined string
+	}{
+		{
+			Stat{},
+			"Stat(0 files, 0 dirs, 0 trees, 0 blobs, 0 errors, 0 B)",
+			"Stat(0 files, 0 dirs, 0 objects, 0 errors, 0 B)",
+		},
+		{
+			Stat{Files: 1, Dirs: 2, Trees: 3, Blobs: 4, Errors: 5, Bytes: 1023},
+			"Stat(1 files, 2 dirs, 3 trees, 4 blobs, 5 errors, 1023 B)",
+			"Stat(1 files, 2 dirs, 7 objects, 5 errors, 1023 B)",
+		},
+		{
+			Stat{Files: 12345, Bytes: 1536, StoredBytes: 1000},
+			"Stat(12345 files, 0 dirs, 0 trees, 0 blobs, 0 errors, 1.5 KiB, 1000 B stored)",
+			"Stat(12345 files, 0 dirs, 0 objects, 0 errors, 1.5 KiB, 1000 B stored)",
+		},
+		{
+			Stat{Bytes: 4509715660, StoredBytes: 3 << 30, Skipped: 17},
+			"Stat(0 files, 0 dirs, 0 trees, 0 blobs, 0 errors, 4.200 GiB, 3.000 GiB stored, 17 skipped)",
+			"Stat(0 files, 0 dirs, 0 objects, 0 errors, 4.200 GiB, 3.000 GiB stored, 17 skipped)",
+		},
+		{
+			Stat{Bytes: 4 << 20, CompressedBytes: 3 << 19},
+			"Stat(0 files, 0 dirs, 0 trees, 0 blobs, 0 errors, 4.00 MiB, 1.50 MiB compressed)",
+			"Stat(0 files, 0 dirs, 0 objects, 0 errors, 4.00 MiB, 1.50 MiB compressed)",
+		},
+		{
+			max,
+			"Stat(18446744073709551615 files, 18446744073709551615 dirs, 18446744073709551615 trees, " +
+				"18446744073709551615 blobs, 18446744073709551615 errors, 16777216.000 TiB, " +
+				"16777216.000 TiB stored, 16777216.000 TiB compressed, 18446744073709551615 skipped)",
+			"Stat(18446744073709551615 files, 18446744073709551615 dirs, 18446744073709551615 objects, " +
+				"18446744073709551615 errors, 16777216.000 TiB, " +
+				"16777216.000 TiB stored, 16777216.000 TiB compressed, 18446744073709551615 skipped)",
+		},
+	}
+
+	for _, test := range tests {
+		if got := test.s.String(); got != test.want {
+			t.Errorf("wrong string, want %q, got %q", test.want, got)
+		}
+		if got := test.s.StringWithOptions(StatOptions{CombineObjects: true}); got != test.combined {
+			t.Errorf("wrong string with combined objects, want %q, got %q", test.combined, got)
+		}
+	}
+}
+
+func BenchmarkStatString(b *testing.B) {
+	s := Stat{Files: 12345, Dirs: 678, Trees: 90, Blobs: 12345, Bytes: 4509715660, StoredBytes: 3 << 30, Skipped: 17}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_ = s.String()
+	}
+}
+
+func BenchmarkStatStringFmt(b *testing.B) {
+	s := Stat{Files: 12345, Dirs: 678, Trees: 90, Blobs: 12345, Bytes: 4509715660, StoredBytes: 3 << 30, Skipped: 17}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_ = statStringFmt(s)
+	}
+}
@@ -0,0 +1,82 @@
+package restic
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// ProgressSample is the state of a Progress recorded on a tick.
+type ProgressSample struct {
+	Time time.Time
+	Stat Stat
+
+	// Rate is the number of bytes per second since the previous sample.
+	Rate float64
+}
+
+// recordHistory appends sample to the history and removes the samples which
+// are older than the configured duration. The caller must hold curM.
+func (p *Progress) recordHistory(sample ProgressSample) {
+	if p.historyDuration <= 0 {
+		return
+	}
+
+	p.history = append(p.history, sample)
+
+	cutoff := sample.Time.Add(-p.historyDuration)
+	i := 0
+	for i < len(p.history) && p.history[i].Time.Before(cutoff) {
+		i++
+	}
+	if i > 0 {
+		p.history = append(p.history[:0], p.history[i:]...)
+	}
+}
+
+// History returns a copy of the samples recorded within the duration set with
+// WithHistoryDuration, the oldest sample first.
+func (p *Progress) History() []ProgressSample {
+	if p == nil {
+		return nil
+	}
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+	return append([]ProgressSample(nil), p.history...)
+}
+
+// recordRate counts rate in the histogram set with WithRateHistogram. The
+// caller must hold curM.
+func (p *Progress) recordRate(rate float64) {
+	if len(p.rateCounts) == 0 {
+		return
+	}
+
+	i := sort.SearchFloat64s(p.rateBuckets, rate)
+	p.rateCounts[i]++
+}
+
+// RateHistogram returns the number of ticks in the current run per bucket of
+// the rate, by the upper bound of the bucket as passed to WithRateHistogram.
+// Ticks with a rate above all buckets are counted under positive infinity.
+// Without WithRateHistogram, nil is returned.
+func (p *Progress) RateHistogram() map[float64]uint64 {
+	if p == nil {
+		return nil
+	}
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+
+	if len(p.rateCounts) == 0 {
+		return nil
+	}
+
+	hist := make(map[float64]uint64, len(p.rateCounts))
+	for i, bound := range p.rateBuckets {
+		hist[bound] += p.rateCounts[i]
+	}
+	hist[math.Inf(1)] = p.rateCounts[len(p.rateBuckets)]
+	return hist
+}
@@ -0,0 +1,115 @@
+package restic
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestProgressHistoryDuration(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker(), WithHistoryDuration(5*time.Second))
+	p.Start()
+	defer p.Done()
+
+	for i := 0; i < 3; i++ {
+		clock.Advance(time.Second)
+		p.Report(Stat{Bytes: 100})
+		p.tick()
+	}
+
+	if h := p.History(); len(h) != 3 {
+		t.Fatalf("expected 3 samples, got %d", len(h))
+	}
+
+	for i := 0; i < 7; i++ {
+		clock.Advance(time.Second)
+		p.Report(Stat{Bytes: 200})
+		p.tick()
+	}
+
+	h := p.History()
+	if len(h) != 6 {
+		t.Fatalf("expected 6 samples within the retention window, got %d", len(h))
+	}
+
+	cutoff := clock.Now().Add(-5 * time.Second)
+	for _, sample := range h {
+		if sample.Time.Before(cutoff) {
+			t.Errorf("sample at %v is older than the retention window", sample.Time)
+		}
+		if sample.Rate != 200 {
+			t.Errorf("wrong rate %v for sample at %v", sample.Rate, sample.Time)
+		}
+	}
+
+	if last := h[len(h)-1]; last.Stat.Bytes != 1700 {
+		t.Errorf("wrong bytes in last sample: %v", last.Stat.Bytes)
+	}
+}
+
+func TestProgressHistoryDisabled(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+	p.Start()
+	defer p.Done()
+
+	clock.Advance(time.Second)
+	p.tick()
+
+	if h := p.History(); len(h) != 0 {
+		t.Errorf("expected no history without WithHistoryDuration, got %v", h)
+	}
+}
+
+func TestProgressRateHistogram(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker(), WithRateHistogram([]float64{1000, 100, 10000}))
+	p.Start()
+
+	// bytes per one second tick
+	for _, bytes := range []uint64{50, 100, 101, 5000, 10000, 20000, 0, 800} {
+		clock.Advance(time.Second)
+		p.Report(Stat{Bytes: bytes})
+		p.tick()
+	}
+
+	want := map[float64]uint64{
+		100:         3,
+		1000:        2,
+		10000:       2,
+		math.Inf(1): 1,
+	}
+
+	hist := p.RateHistogram()
+	if len(hist) != len(want) {
+		t.Fatalf("wrong histogram, want %v, got %v", want, hist)
+	}
+	for bound, n := range want {
+		if hist[bound] != n {
+			t.Errorf("bucket %v: want %d ticks, got %d", bound, n, hist[bound])
+		}
+	}
+
+	// a new run starts with an empty histogram
+	p.Done()
+	p.Start()
+	defer p.Done()
+	for bound, n := range p.RateHistogram() {
+		if n != 0 {
+			t.Errorf("bucket %v not reset: %d", bound, n)
+		}
+	}
+}
+
+func TestProgressRateHistogramDisabled(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+	p.Start()
+	defer p.Done()
+
+	p.Report(Stat{Bytes: 100})
+	p.tick()
+	if hist := p.RateHistogram(); hist != nil {
+		t.Errorf("expected no histogram, got %v", hist)
+	}
+}
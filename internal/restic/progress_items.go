@@ -0,0 +1,145 @@
+package restic
+
+import "math"
+
+// ItemSelector returns the number of items in s. What counts as an item
+// depends on the display, for example only files and dirs or also trees and
+// blobs.
+type ItemSelector func(s Stat) uint64
+
+// FilesAndDirs is the default ItemSelector, it counts files and dirs. To also
+// count trees and blobs, Stat.TotalItems can be used.
+func FilesAndDirs(s Stat) uint64 {
+	return addSaturated(s.Files, s.Dirs)
+}
+
+// items returns the number of items in s with the configured selector.
+func (p *Progress) items(s Stat) uint64 {
+	if p.itemSelector == nil {
+		return FilesAndDirs(s)
+	}
+	return p.itemSelector(s)
+}
+
+// Items returns the number of items processed so far.
+func (p *Progress) Items() uint64 {
+	if p == nil {
+		return 0
+	}
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+	return p.items(p.curLocked())
+}
+
+// ItemsPercent returns how many of the total items have been processed, as a
+// value between 0 and 100. Zero is returned if the total has no items.
+func (p *Progress) ItemsPercent() float64 {
+	if p == nil {
+		return 0
+	}
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+	return percent(p.items(p.curLocked()), p.items(p.total))
+}
+
+// RemainingItems returns how many of the total items have not been processed
+// yet, it is zero when more than the total was reported. The bool is false if
+// the total has no items.
+func (p *Progress) RemainingItems() (uint64, bool) {
+	if p == nil {
+		return 0, false
+	}
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+
+	total := p.items(p.total)
+	if total == 0 {
+		return 0, false
+	}
+	return delta(total, p.items(p.curLocked())), true
+}
+
+// ItemRate returns the number of items per second processed since Start().
+func (p *Progress) ItemRate() float64 {
+	if p == nil {
+		return 0
+	}
+
+	now := p.now()
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+
+	sec := p.elapsed(now).Seconds()
+	if sec <= 0 {
+		return 0
+	}
+	return float64(p.items(p.curLocked())) / sec
+}
+
+// ItemsPerSecond returns the exponential moving average of the items per
+// second, counted with the ItemSelector. It is sampled on each tick and
+// smoothed like SmoothedRate, until enough samples have been recorded the
+// average since Start() is returned.
+func (p *Progress) ItemsPerSecond() float64 {
+	if p == nil {
+		return 0
+	}
+
+	now := p.now()
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+
+	if p.rateSamples >= minRateSamples {
+		return p.itemEMA
+	}
+
+	sec := p.elapsed(now).Seconds()
+	if sec <= 0 {
+		return 0
+	}
+	return float64(p.items(p.curLocked())) / sec
+}
+
+// SetCompletionModel makes PercentDone blend the percentage of bytes and the
+// percentage of items processed, weighted by bytesWeight and itemsWeight.
+// This is useful for a single bar over phases of which some are bound by the
+// bytes and others by the number of items. Negative weights are treated as
+// zero, setting both to zero restores the default of using the bytes only.
+func (p *Progress) SetCompletionModel(bytesWeight, itemsWeight float64) {
+	if p == nil {
+		return
+	}
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+
+	p.bytesWeight = math.Max(bytesWeight, 0)
+	p.itemsWeight = math.Max(itemsWeight, 0)
+}
+
+// blendedPercent returns the weighted mean of the percentage of bytes and of
+// items processed. A part for which the total is zero is left out, so that it
+// does not hold the percentage back. The caller must hold curM.
+func (p *Progress) blendedPercent() float64 {
+	var sum, weights float64
+	cur := p.curLocked()
+
+	if p.total.Bytes != 0 {
+		sum += p.bytesWeight * percent(cur.Bytes, p.total.Bytes)
+		weights += p.bytesWeight
+	}
+	if total := p.items(p.total); total != 0 {
+		sum += p.itemsWeight * percent(p.items(cur), total)
+		weights += p.itemsWeight
+	}
+
+	if weights == 0 {
+		return 0
+	}
+	return sum / weights
+}
@@ -0,0 +1,214 @@
+package restic
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestProgressItemsDefault(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+	p.SetTotal(Stat{Files: 8, Dirs: 2, Blobs: 100})
+	p.Start()
+	defer p.Done()
+
+	clock.Advance(2 * time.Second)
+	p.Report(Stat{Files: 4, Dirs: 1, Blobs: 90})
+
+	if n := p.Items(); n != 5 {
+		t.Errorf("wrong number of items %d", n)
+	}
+	if pct := p.ItemsPercent(); pct != 50 {
+		t.Errorf("wrong percentage %v", pct)
+	}
+	if r := p.ItemRate(); r != 2.5 {
+		t.Errorf("wrong item rate %v", r)
+	}
+}
+
+func TestProgressItemSelector(t *testing.T) {
+	clock := newFakeClock()
+	withBlobs := func(s Stat) uint64 { return s.Files + s.Dirs + s.Blobs }
+	p := NewProgress(WithClock(clock), WithoutTicker(), WithItemSelector(withBlobs))
+	p.SetTotal(Stat{Files: 8, Dirs: 2, Blobs: 190})
+	p.Start()
+	defer p.Done()
+
+	clock.Advance(2 * time.Second)
+	p.Report(Stat{Files: 4, Dirs: 1, Blobs: 145})
+
+	if n := p.Items(); n != 150 {
+		t.Errorf("wrong number of items %d", n)
+	}
+	if pct := p.ItemsPercent(); pct != 75 {
+		t.Errorf("wrong percentage %v", pct)
+	}
+	if r := p.ItemRate(); r != 75 {
+		t.Errorf("wrong item rate %v", r)
+	}
+}
+
+func TestProgressCompletionModel(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+	p.SetTotal(Stat{Files: 90, Dirs: 10, Bytes: 1000})
+	p.SetCompletionModel(3, 1)
+	p.Start()
+	defer p.Done()
+
+	var tests = []struct {
+		report Stat
+		want   float64
+	}{
+		{Stat{}, 0},
+		// 0% of the bytes, 40% of the items
+		{Stat{Files: 36, Dirs: 4}, 10},
+		// 50% of the bytes, 40% of the items
+		{Stat{Bytes: 500}, 47.5},
+		// 100% of the bytes, 100% of the items
+		{Stat{Files: 54, Dirs: 6, Bytes: 500}, 100},
+	}
+
+	for _, test := range tests {
+		p.Report(test.report)
+		if pct := p.PercentDone(); pct != test.want {
+			t.Errorf("wrong percentage after %v, want %v, got %v", p.Current(), test.want, pct)
+		}
+	}
+
+	p.SetCompletionModel(0, 1)
+	p.SetTotal(Stat{Files: 90, Dirs: 10, Bytes: 2000})
+	if pct := p.PercentDone(); pct != 100 {
+		t.Errorf("wrong percentage by items only %v", pct)
+	}
+
+	// both zero restores the percentage of the bytes
+	p.SetCompletionModel(0, -1)
+	if pct := p.PercentDone(); pct != 50 {
+		t.Errorf("wrong percentage without a model %v", pct)
+	}
+}
+
+func TestProgressCompletionModelMissingTotal(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+	p.SetTotal(Stat{Files: 10})
+	p.SetCompletionModel(1, 1)
+	p.Start()
+	defer p.Done()
+
+	// without a byte total, only the items are considered
+	p.Report(Stat{Files: 3, Bytes: 500})
+	if pct := p.PercentDone(); pct != 30 {
+		t.Errorf("wrong percentage %v", pct)
+	}
+
+	p.SetTotal(Stat{})
+	if pct := p.PercentDone(); pct != 0 {
+		t.Errorf("expected zero without a total, got %v", pct)
+	}
+}
+
+func TestProgressItemsPerSecond(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker(), WithItemSelector(Stat.TotalItems))
+	p.Start()
+	defer p.Done()
+
+	// 10 items per second, as many bytes
+	for i := 0; i < 10; i++ {
+		clock.Advance(time.Second)
+		p.Report(Stat{Files: 4, Dirs: 1, Trees: 2, Blobs: 3, Bytes: 10})
+		p.tick()
+	}
+	if r := p.ItemsPerSecond(); math.Abs(r-10) > 1e-9 {
+		t.Errorf("wrong items per second at a steady rate: %v", r)
+	}
+
+	// step to 100 items per second
+	for i := 0; i < 5; i++ {
+		clock.Advance(time.Second)
+		p.Report(Stat{Blobs: 100, Bytes: 100})
+		p.tick()
+	}
+
+	r := p.ItemsPerSecond()
+	if r < 80 || r > 100 {
+		t.Errorf("items per second %v did not follow the step change", r)
+	}
+	if avg := float64(p.Current().TotalItems()) / p.Elapsed().Seconds(); r <= avg {
+		t.Errorf("items per second %v not above the average %v", r, avg)
+	}
+
+	// the same smoothing is used as for the bytes
+	if b := p.SmoothedRate(); math.Abs(r-b) > 1e-9 {
+		t.Errorf("items per second %v differs from the smoothed rate of the same steps %v", r, b)
+	}
+}
+
+func TestProgressItemsPerSecondFewSamples(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+	p.Start()
+	defer p.Done()
+
+	if r := p.ItemsPerSecond(); r != 0 {
+		t.Errorf("expected zero before Start time has passed, got %v", r)
+	}
+
+	// without enough samples, the average is used, trees are not counted by
+	// the default selector
+	clock.Advance(4 * time.Second)
+	p.Report(Stat{Files: 6, Trees: 2})
+	p.tick()
+	if r := p.ItemsPerSecond(); r != 1.5 {
+		t.Errorf("wrong average items per second %v", r)
+	}
+}
+
+func TestProgressRemaining(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+	p.Start()
+	defer p.Done()
+
+	// no total
+	p.Report(Stat{Files: 1, Bytes: 100})
+	if n, ok := p.RemainingBytes(); n != 0 || ok {
+		t.Errorf("expected no remaining bytes without a total, got %d (ok %v)", n, ok)
+	}
+	if n, ok := p.RemainingItems(); n != 0 || ok {
+		t.Errorf("expected no remaining items without a total, got %d (ok %v)", n, ok)
+	}
+
+	var tests = []struct {
+		report       Stat
+		bytes, items uint64
+	}{
+		// partial
+		{Stat{}, 900, 9},
+		{Stat{Files: 3, Dirs: 1, Bytes: 400}, 500, 5},
+		// complete
+		{Stat{Files: 5, Bytes: 500}, 0, 0},
+		// over the total
+		{Stat{Files: 2, Bytes: 300}, 0, 0},
+	}
+
+	p.SetTotal(Stat{Files: 8, Dirs: 2, Bytes: 1000})
+	for _, test := range tests {
+		p.Report(test.report)
+		if n, ok := p.RemainingBytes(); n != test.bytes || !ok {
+			t.Errorf("at %v: want %d remaining bytes, got %d (ok %v)", p.Current(), test.bytes, n, ok)
+		}
+		if n, ok := p.RemainingItems(); n != test.items || !ok {
+			t.Errorf("at %v: want %d remaining items, got %d (ok %v)", p.Current(), test.items, n, ok)
+		}
+	}
+
+	// only one of the totals is known
+	p.SetTotal(Stat{Bytes: 2000})
+	if n, ok := p.RemainingBytes(); n != 700 || !ok {
+		t.Errorf("want 700 remaining bytes, got %d (ok %v)", n, ok)
+	}
+	if _, ok := p.RemainingItems(); ok {
+		t.Error("remaining items reported without a total of items")
+	}
+}
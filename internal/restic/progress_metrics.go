@@ -0,0 +1,88 @@
+package restic
+
+import (
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// openMetricsHelp is the help text of each counter by its name in
+// Stat.Fields.
+var openMetricsHelp = map[string]string{
+	"files":            "Number of files processed.",
+	"dirs":             "Number of directories processed.",
+	"bytes":            "Number of bytes processed.",
+	"trees":            "Number of trees processed.",
+	"blobs":            "Number of blobs processed.",
+	"errors":           "Number of errors encountered.",
+	"stored_bytes":     "Number of bytes written to the repository.",
+	"skipped":          "Number of unchanged files skipped.",
+	"compressed_bytes": "Number of bytes after compression.",
+}
+
+// openMetricsHelpText returns the help text for the counter name.
+func openMetricsHelpText(name string) string {
+	if help, ok := openMetricsHelp[name]; ok {
+		return help
+	}
+	return "Number of " + strings.Replace(name, "_", " ", -1) + "."
+}
+
+// validLabelName returns true if name can be used as a label name.
+func validLabelName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// labelEscaper escapes label values as required by OpenMetrics.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WriteOpenMetrics writes the counters of s to w in the OpenMetrics text
+// format, each as a counter with the given labels. The output is terminated
+// by "# EOF", so it can be pushed to a gateway as is. An error is returned if
+// a label name is invalid.
+func (s Stat) WriteOpenMetrics(w io.Writer, labels map[string]string) error {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		if !validLabelName(name) {
+			return errors.Errorf("invalid label name %q", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var set string
+	if len(names) > 0 {
+		pairs := make([]string, 0, len(names))
+		for _, name := range names {
+			pairs = append(pairs, name+`="`+labelEscaper.Replace(labels[name])+`"`)
+		}
+		set = "{" + strings.Join(pairs, ",") + "}"
+	}
+
+	var b strings.Builder
+	for _, c := range s.Fields() {
+		name := "restic_" + c.Name
+		b.WriteString("# TYPE " + name + " counter\n")
+		b.WriteString("# HELP " + name + " " + openMetricsHelpText(c.Name) + "\n")
+		b.WriteString(name + "_total" + set + " " + strconv.FormatUint(c.Value, 10) + "\n")
+	}
+	b.WriteString("# EOF\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,76 @@
+package restic
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestStatWriteOpenMetrics(t *testing.T) {
+	s := Stat{Files: 3, Dirs: 1, Bytes: 4096, Errors: 2, StoredBytes: 1024, CompressedBytes: 2048}
+
+	buf := bytes.NewBuffer(nil)
+	err := s.WriteOpenMetrics(buf, map[string]string{
+		"repo": "s3:bucket",
+		"host": `db"1`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# TYPE restic_files counter
+# HELP restic_files Number of files processed.
+restic_files_total{host="db\"1",repo="s3:bucket"} 3
+# TYPE restic_dirs counter
+# HELP restic_dirs Number of directories processed.
+restic_dirs_total{host="db\"1",repo="s3:bucket"} 1
+# TYPE restic_bytes counter
+# HELP restic_bytes Number of bytes processed.
+restic_bytes_total{host="db\"1",repo="s3:bucket"} 4096
+# TYPE restic_trees counter
+# HELP restic_trees Number of trees processed.
+restic_trees_total{host="db\"1",repo="s3:bucket"} 0
+# TYPE restic_blobs counter
+# HELP restic_blobs Number of blobs processed.
+restic_blobs_total{host="db\"1",repo="s3:bucket"} 0
+# TYPE restic_errors counter
+# HELP restic_errors Number of errors encountered.
+restic_errors_total{host="db\"1",repo="s3:bucket"} 2
+# TYPE restic_stored_bytes counter
+# HELP restic_stored_bytes Number of bytes written to the repository.
+restic_stored_bytes_total{host="db\"1",repo="s3:bucket"} 1024
+# TYPE restic_skipped counter
+# HELP restic_skipped Number of unchanged files skipped.
+restic_skipped_total{host="db\"1",repo="s3:bucket"} 0
+# TYPE restic_compressed_bytes counter
+# HELP restic_compressed_bytes Number of bytes after compression.
+restic_compressed_bytes_total{host="db\"1",repo="s3:bucket"} 2048
+# EOF
+`
+	if got := buf.String(); got != want {
+		t.Errorf("wrong output, want\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestStatWriteOpenMetricsNoLabels(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	if err := (Stat{Files: 1}).WriteOpenMetrics(buf, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), "\nrestic_files_total 1\n") {
+		t.Errorf("wrong output without labels:\n%s", buf.String())
+	}
+}
+
+func TestStatWriteOpenMetricsInvalidLabel(t *testing.T) {
+	for _, name := range []string{"", "1abc", "a-b", "ä"} {
+		buf := bytes.NewBuffer(nil)
+		if err := (Stat{}).WriteOpenMetrics(buf, map[string]string{name: "x"}); err == nil {
+			t.Errorf("no error for label name %q", name)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("output written for invalid label name %q", name)
+		}
+	}
+}
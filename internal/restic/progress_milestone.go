@@ -0,0 +1,79 @@
+package restic
+
+// MilestoneFunc is called by a milestone registered with RegisterMilestone
+// with the multiple of the step which was reached and the statistics at that
+// time.
+type MilestoneFunc func(reached uint64, s Stat)
+
+// milestone is a callback registered with RegisterMilestone.
+type milestone struct {
+	field StatField
+	step  uint64
+	fn    MilestoneFunc
+}
+
+// milestoneEvent is a milestone which was reached by a Report.
+type milestoneEvent struct {
+	fn      MilestoneFunc
+	reached uint64
+}
+
+// fieldValue returns the counter of s selected by field, which must select a
+// single counter.
+func fieldValue(s Stat, field StatField) uint64 {
+	switch field {
+	case FieldFiles:
+		return s.Files
+	case FieldDirs:
+		return s.Dirs
+	case FieldBytes:
+		return s.Bytes
+	case FieldTrees:
+		return s.Trees
+	case FieldBlobs:
+		return s.Blobs
+	case FieldErrors:
+		return s.Errors
+	case FieldStoredBytes:
+		return s.StoredBytes
+	case FieldSkipped:
+		return s.Skipped
+	case FieldCompressedBytes:
+		return s.CompressedBytes
+	default:
+		return 0
+	}
+}
+
+// RegisterMilestone registers fn to be called each time the counter selected
+// by field, for example FieldBytes, reaches a multiple of step. When a single
+// Report crosses several multiples, fn is called only once with the highest
+// of them, so a small step cannot cause a flood of calls. The field must
+// select a single counter and step must not be zero, otherwise the milestone
+// never fires.
+func (p *Progress) RegisterMilestone(field StatField, step uint64, fn MilestoneFunc) {
+	if p == nil || step == 0 {
+		return
+	}
+
+	p.curM.Lock()
+	// reports from ReportFast must not cross the milestone unnoticed
+	p.foldFast()
+	p.fastUntil.Store(0)
+	p.milestones = append(p.milestones, milestone{field: field, step: step, fn: fn})
+	p.curM.Unlock()
+}
+
+// reachedMilestones returns the milestones reached when the statistics went
+// from prev to cur. The caller must hold curM.
+func (p *Progress) reachedMilestones(prev, cur Stat) []milestoneEvent {
+	var events []milestoneEvent
+	for _, m := range p.milestones {
+		from := fieldValue(prev, m.field) / m.step
+		to := fieldValue(cur, m.field) / m.step
+		if to > from {
+			events = append(events, milestoneEvent{fn: m.fn, reached: to * m.step})
+		}
+	}
+	return events
+}
@@ -0,0 +1,79 @@
+package restic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProgressMilestones(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+
+	var bytes, files []uint64
+	p.RegisterMilestone(FieldBytes, 1<<30, func(reached uint64, s Stat) {
+		if s.Bytes < reached {
+			t.Errorf("milestone %d fired with %d bytes", reached, s.Bytes)
+		}
+		bytes = append(bytes, reached)
+	})
+	p.RegisterMilestone(FieldFiles, 10000, func(reached uint64, s Stat) {
+		files = append(files, reached)
+	})
+
+	p.Start()
+	defer p.Done()
+
+	p.Report(Stat{Files: 9999, Bytes: 1<<30 - 1})
+	if len(bytes) != 0 || len(files) != 0 {
+		t.Fatalf("milestones fired too early: %v %v", bytes, files)
+	}
+
+	p.Report(Stat{Files: 1, Bytes: 1})
+	// one large report crosses several milestones at once, only the highest
+	// is reported
+	p.Report(Stat{Files: 25000, Bytes: 3 << 30})
+
+	wantBytes := []uint64{1 << 30, 4 << 30}
+	if !reflect.DeepEqual(bytes, wantBytes) {
+		t.Errorf("wrong byte milestones, want %v, got %v", wantBytes, bytes)
+	}
+
+	wantFiles := []uint64{10000, 30000}
+	if !reflect.DeepEqual(files, wantFiles) {
+		t.Errorf("wrong file milestones, want %v, got %v", wantFiles, files)
+	}
+}
+
+func TestProgressMilestoneSmallStep(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+
+	var reached []uint64
+	p.RegisterMilestone(FieldBytes, 1, func(n uint64, s Stat) {
+		reached = append(reached, n)
+	})
+
+	p.Start()
+	defer p.Done()
+
+	p.Report(Stat{Bytes: 1 << 40})
+	p.Report(Stat{Bytes: 1})
+
+	want := []uint64{1 << 40, 1<<40 + 1}
+	if !reflect.DeepEqual(reached, want) {
+		t.Errorf("wrong milestones, want %v, got %v", want, reached)
+	}
+}
+
+func TestFieldValue(t *testing.T) {
+	s := Stat{Files: 1, Dirs: 2, Bytes: 3, Trees: 4, Blobs: 5, Errors: 6, StoredBytes: 7, Skipped: 8, CompressedBytes: 9}
+	fields := []StatField{FieldFiles, FieldDirs, FieldBytes, FieldTrees, FieldBlobs, FieldErrors, FieldStoredBytes, FieldSkipped, FieldCompressedBytes}
+
+	for i, field := range fields {
+		if v := fieldValue(s, field); v != uint64(i+1) {
+			t.Errorf("field %b: want %d, got %d", field, i+1, v)
+		}
+	}
+
+	if v := fieldValue(s, FieldFiles|FieldDirs); v != 0 {
+		t.Errorf("combined fields selected %d", v)
+	}
+}
@@ -0,0 +1,146 @@
+package restic
+
+import (
+	"sort"
+	"time"
+
+	"github.com/restic/restic/internal/debug"
+)
+
+// ProgressOption configures a Progress, it is passed to NewProgress.
+type ProgressOption func(p *Progress)
+
+// WithClock sets the clock used for all time computations.
+func WithClock(c Clock) ProgressOption {
+	return func(p *Progress) {
+		p.clock = c
+	}
+}
+
+// WithoutTicker disables the background reporter goroutine. OnUpdate is then
+// only called synchronously from Report (throttled), Flush and Done.
+func WithoutTicker() ProgressOption {
+	return func(p *Progress) {
+		p.noTicker = true
+		p.updateOnReport = true
+	}
+}
+
+// WithManualTick disables the background reporter goroutine like
+// WithoutTicker, the ticks are instead driven by calling Tick. Report does
+// not call OnUpdate, so the callbacks only run from Tick, Flush and Done.
+// Together with a fake clock passed to WithClock, this makes the updates
+// fully deterministic, for example in tests.
+func WithManualTick() ProgressOption {
+	return func(p *Progress) {
+		p.noTicker = true
+		p.updateOnReport = false
+	}
+}
+
+// WithStallTimeout sets a function which is called by the reporter when
+// Report has not been called for at least d. It is called once per stall,
+// reporting again re-arms it.
+func WithStallTimeout(d time.Duration, onStall func(lastActivity time.Time)) ProgressOption {
+	return func(p *Progress) {
+		p.stallTimeout = d
+		p.onStall = onStall
+	}
+}
+
+// WithName sets a name which identifies the Progress, for example the
+// repository it is used for, in log messages.
+func WithName(name string) ProgressOption {
+	return func(p *Progress) {
+		p.name = name
+	}
+}
+
+// WithHistoryDuration keeps the samples recorded on each tick for d, they are
+// returned by History. Without this option, no history is kept.
+func WithHistoryDuration(d time.Duration) ProgressOption {
+	return func(p *Progress) {
+		p.historyDuration = d
+	}
+}
+
+// WithRateHistogram counts the rate measured on each tick of the reporter in
+// a histogram, which is returned by RateHistogram. The buckets are the upper
+// bounds in bytes per second, a rate is counted in the smallest bucket which
+// is at least as large.
+func WithRateHistogram(buckets []float64) ProgressOption {
+	return func(p *Progress) {
+		p.rateBuckets = append([]float64(nil), buckets...)
+		sort.Float64s(p.rateBuckets)
+	}
+}
+
+// WithCallbackTimeout runs OnUpdate with a watchdog. If a call does not return
+// within d, it is reported to OnCallbackError and abandoned, so that Report
+// and the reporter do not block on a deadlocked callback. Go cannot stop the
+// abandoned call, it keeps running in its own goroutine. Updates are dropped
+// until it returns, so that calls do not pile up and OnUpdate never runs
+// concurrently with itself. It also means OnUpdate runs in a different
+// goroutine than the one calling Report.
+func WithCallbackTimeout(d time.Duration) ProgressOption {
+	return func(p *Progress) {
+		p.callbackTimeout = d
+	}
+}
+
+// WithItemSelector sets how the items of a Stat are counted by Items,
+// ItemsPercent and ItemRate. The default is FilesAndDirs.
+func WithItemSelector(sel ItemSelector) ProgressOption {
+	return func(p *Progress) {
+		p.itemSelector = sel
+	}
+}
+
+// WithAlignedTicks schedules each tick of the reporter at the next multiple
+// of the interval on the clock, for example at the top of each second. Several
+// Progress instances with the same interval then tick at the same time
+// instead of staggered by their start times, and the ticks do not drift off
+// the boundaries over time.
+func WithAlignedTicks() ProgressOption {
+	return func(p *Progress) {
+		p.alignTicks = true
+	}
+}
+
+// WithSkipEmptyFirstTick suppresses the update of the first tick of each run
+// if Report has not been called before it. On fast starts the ticker may fire
+// before any data has arrived, which would otherwise show a misleading update
+// like "0 B in 0:01". Later ticks are always delivered, even without data.
+func WithSkipEmptyFirstTick() ProgressOption {
+	return func(p *Progress) {
+		p.skipEmptyFirstTick = true
+	}
+}
+
+// WithMinRateGrace sets how long the rate must stay below the minimum set
+// with SetMinRate before the violation is reported, so that short drops are
+// ignored. Without this option, the first tick below the minimum reports it.
+func WithMinRateGrace(d time.Duration) ProgressOption {
+	return func(p *Progress) {
+		p.minRateGrace = d
+	}
+}
+
+// WithAdaptiveInterval lets the interval of the ticker adapt to the rate of
+// change: it is halved when a tick sees a burst of data and doubled when a tick
+// sees no new data at all, but always stays between min and max. The interval
+// starts at max if no interval was configured. The bounds must satisfy
+// 0 < min <= max, otherwise the option is ignored and the interval stays
+// fixed.
+func WithAdaptiveInterval(min, max time.Duration) ProgressOption {
+	return func(p *Progress) {
+		if min <= 0 || min > max {
+			debug.Log("ignoring invalid adaptive interval bounds %v and %v", min, max)
+			return
+		}
+
+		p.minInterval = min
+		p.maxInterval = max
+		p.d = clampInterval(p.d, min, max)
+	}
+}
@@ -0,0 +1,289 @@
+package restic
+
+import "time"
+
+// rateSmoothing is the weight of the most recent sample in the exponential
+// moving average of the transfer rate.
+const rateSmoothing = 0.3
+
+// minRateSamples is the number of samples needed before the smoothed rate is
+// used instead of the average rate.
+const minRateSamples = 3
+
+// sampleRate updates the smoothed rates of the bytes and the items with what
+// was processed since the last sample and returns the rate of bytes of this
+// sample. The bool is false if no time has passed since the last sample. When
+// the clock jumped backwards, the next sample is measured from now. The
+// caller must hold curM.
+func (p *Progress) sampleRate(now time.Time, cur Stat) (float64, bool) {
+	bytes, items := cur.Bytes, p.items(cur)
+
+	if now.Before(p.lastSampleTime) {
+		p.lastSampleTime = now
+		p.lastSampleBytes = bytes
+		p.lastSampleItems = items
+		return 0, false
+	}
+
+	dt := now.Sub(p.lastSampleTime).Seconds()
+	if dt <= 0 {
+		return 0, false
+	}
+
+	rate := float64(delta(bytes, p.lastSampleBytes)) / dt
+	itemRate := float64(delta(items, p.lastSampleItems)) / dt
+
+	if p.rateSamples == 0 {
+		p.ema = rate
+		p.itemEMA = itemRate
+	} else {
+		p.ema = rateSmoothing*rate + (1-rateSmoothing)*p.ema
+		p.itemEMA = rateSmoothing*itemRate + (1-rateSmoothing)*p.itemEMA
+	}
+	p.rateSamples++
+
+	p.lastSampleTime = now
+	p.lastSampleBytes = bytes
+	p.lastSampleItems = items
+
+	return rate, true
+}
+
+// delta returns cur-prev, or zero if cur is smaller.
+func delta(cur, prev uint64) uint64 {
+	if cur > prev {
+		return cur - prev
+	}
+	return 0
+}
+
+// averageRate returns the bytes per second since the start. The caller must
+// hold curM.
+func (p *Progress) averageRate() float64 {
+	sec := p.elapsed(p.now()).Seconds()
+	if sec <= 0 {
+		return 0
+	}
+	return float64(p.curLocked().Bytes) / sec
+}
+
+// idleGap returns the idle part of the time since the last report at now,
+// which is the time beyond the stall timeout. Without a stall timeout nothing
+// is idle. The caller must hold curM.
+func (p *Progress) idleGap(now time.Time) time.Duration {
+	if p.stallTimeout <= 0 {
+		return 0
+	}
+
+	gap := since(now, p.lastActivity)
+	if gap <= p.stallTimeout {
+		return 0
+	}
+	return gap - p.stallTimeout
+}
+
+// activeRate returns the bytes per second of the time which was not idle.
+// The caller must hold curM.
+func (p *Progress) activeRate() float64 {
+	now := p.now()
+	active := p.elapsed(now) - p.idleTime - p.idleGap(now)
+	if active <= 0 {
+		return 0
+	}
+	return float64(p.curLocked().Bytes) / active.Seconds()
+}
+
+// smoothedRate returns the exponential moving average of the bytes per
+// second, or the average rate if not enough samples have been recorded yet.
+// The caller must hold curM.
+func (p *Progress) smoothedRate() float64 {
+	if p.rateSamples < minRateSamples {
+		return p.averageRate()
+	}
+	return p.ema
+}
+
+// eta returns the time needed to process the remaining bytes at rate. If
+// only the number of files is known for the total, the estimate is based on
+// the files instead, see filesETA. The bool is false if there is no estimate
+// because no total is set or nothing was processed yet. The caller must hold
+// curM.
+func (p *Progress) eta(rate float64) (time.Duration, bool) {
+	if p.total.Bytes == 0 {
+		return p.filesETA()
+	}
+	if rate <= 0 {
+		return 0, false
+	}
+
+	remaining := p.total.Sub(p.curLocked()).Bytes
+	return time.Duration(float64(remaining) / rate * float64(time.Second)), true
+}
+
+// filesETA returns the time needed to process the remaining files at the
+// average number of files per second since the start. The bool is false if
+// there is no estimate, like for eta. The caller must hold curM.
+func (p *Progress) filesETA() (time.Duration, bool) {
+	cur := p.curLocked()
+	if p.total.Files == 0 || cur.Files == 0 {
+		return 0, false
+	}
+
+	elapsed := p.elapsed(p.now())
+	remaining := p.total.Sub(cur).Files
+	return time.Duration(float64(remaining) / float64(cur.Files) * float64(elapsed)), true
+}
+
+// AverageRate returns the number of bytes per second processed since Start().
+func (p *Progress) AverageRate() float64 {
+	if p == nil {
+		return 0
+	}
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+	return p.averageRate()
+}
+
+// ActiveRate returns the number of bytes per second processed since Start(),
+// not counting the time the operation was idle. When no report arrives for
+// longer than the stall timeout set with WithStallTimeout, the time beyond
+// it is idle, so long stalls do not lower the rate like in AverageRate.
+// Without a stall timeout, it is the same as AverageRate.
+func (p *Progress) ActiveRate() float64 {
+	if p == nil {
+		return 0
+	}
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+	return p.activeRate()
+}
+
+// SmoothedRate returns the exponential moving average of the number of bytes
+// per second, it is sampled on each tick. Until enough samples have been
+// recorded, the average rate is returned.
+func (p *Progress) SmoothedRate() float64 {
+	if p == nil {
+		return 0
+	}
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+	return p.smoothedRate()
+}
+
+// ETA returns the estimated time remaining based on the average rate since
+// Start(). When the total has no bytes but a number of files, for example
+// from a scan which only counted them, the files processed are used instead.
+// Zero is returned when no total is set or nothing was processed.
+func (p *Progress) ETA() time.Duration {
+	if p == nil {
+		return 0
+	}
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+	d, _ := p.eta(p.averageRate())
+	return d
+}
+
+// ETATime returns the time at which the operation is expected to finish, it
+// is the current time of the clock plus ETA. The zero time is returned when
+// there is no estimate, because no total is set or nothing was processed.
+func (p *Progress) ETATime() time.Time {
+	if p == nil {
+		return time.Time{}
+	}
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+	d, ok := p.eta(p.averageRate())
+	if !ok {
+		return time.Time{}
+	}
+	return p.now().Add(d)
+}
+
+// ETASmoothed returns the estimated time remaining based on the smoothed
+// rate, so that it reacts faster to changes in throughput than ETA. Until
+// enough samples have been recorded, the average rate is used. The rate is
+// only sampled for bytes, so a total with only files is estimated like ETA.
+func (p *Progress) ETASmoothed() time.Duration {
+	if p == nil {
+		return 0
+	}
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+	d, _ := p.eta(p.smoothedRate())
+	return d
+}
+
+// ResetRateStats clears the smoothed rate and the history, for example at the
+// start of a new phase of an operation. The next sample is measured from now.
+// The counters and the start time are not changed, so AverageRate and Elapsed
+// still cover the whole run.
+func (p *Progress) ResetRateStats() {
+	if p == nil {
+		return
+	}
+
+	now := p.now()
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+
+	p.lastSampleTime = now
+	cur := p.curLocked()
+	p.lastSampleBytes = cur.Bytes
+	p.lastSampleItems = p.items(cur)
+	p.rateSamples = 0
+	p.ema = 0
+	p.itemEMA = 0
+	p.history = nil
+}
+
+// SetMinRate sets a minimum rate in bytes per second which is checked on each
+// tick of the reporter. When the smoothed rate drops below it, onViolation is
+// called, or only once it stayed below for the grace period set with
+// WithMinRateGrace. It is called again only after the rate has recovered and
+// then dropped below the minimum again. A rate of zero disables the check.
+func (p *Progress) SetMinRate(bytesPerSec float64, onViolation func()) {
+	if p == nil {
+		return
+	}
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+
+	p.minRate = bytesPerSec
+	p.onMinRate = onViolation
+	p.belowMinRate = time.Time{}
+	p.minRateViolated = false
+}
+
+// checkMinRate returns true if the callback for the minimum rate must be
+// called at now. The caller must hold curM.
+func (p *Progress) checkMinRate(now time.Time) bool {
+	if p.minRate <= 0 || p.onMinRate == nil {
+		return false
+	}
+
+	if p.smoothedRate() >= p.minRate {
+		p.belowMinRate = time.Time{}
+		p.minRateViolated = false
+		return false
+	}
+
+	if p.belowMinRate.IsZero() {
+		p.belowMinRate = now
+	}
+
+	if p.minRateViolated || since(now, p.belowMinRate) < p.minRateGrace {
+		return false
+	}
+
+	p.minRateViolated = true
+	return true
+}
@@ -0,0 +1,104 @@
+package restic
+
+import "time"
+
+// StatField selects counters of a Stat, values can be combined with |.
+type StatField uint
+
+// These are the counters of a Stat which can be selected with a StatField.
+const (
+	FieldFiles StatField = 1 << iota
+	FieldDirs
+	FieldBytes
+	FieldTrees
+	FieldBlobs
+	FieldErrors
+	FieldStoredBytes
+	FieldSkipped
+	FieldCompressedBytes
+
+	// FieldAll selects all counters.
+	FieldAll = FieldFiles | FieldDirs | FieldBytes | FieldTrees | FieldBlobs |
+		FieldErrors | FieldStoredBytes | FieldSkipped | FieldCompressedBytes
+)
+
+// changedFields returns the counters which differ between a and b.
+func changedFields(a, b Stat) StatField {
+	var f StatField
+	for _, c := range []struct {
+		field StatField
+		a, b  uint64
+	}{
+		{FieldFiles, a.Files, b.Files},
+		{FieldDirs, a.Dirs, b.Dirs},
+		{FieldBytes, a.Bytes, b.Bytes},
+		{FieldTrees, a.Trees, b.Trees},
+		{FieldBlobs, a.Blobs, b.Blobs},
+		{FieldErrors, a.Errors, b.Errors},
+		{FieldStoredBytes, a.StoredBytes, b.StoredBytes},
+		{FieldSkipped, a.Skipped, b.Skipped},
+		{FieldCompressedBytes, a.CompressedBytes, b.CompressedBytes},
+	} {
+		if c.a != c.b {
+			f |= c.field
+		}
+	}
+	return f
+}
+
+// subscription is a callback registered with Subscribe.
+type subscription struct {
+	fn   ProgressFunc
+	mask StatField
+	// last are the statistics passed to fn the last time
+	last Stat
+	// noTicker skips the updates from the ticker
+	noTicker bool
+}
+
+// SubscribeOption configures a callback registered with Subscribe.
+type SubscribeOption func(s *subscription)
+
+// WithoutTickerUpdates only calls the callback for updates from Report, Flush
+// and Done, not for the periodic updates of the ticker.
+func WithoutTickerUpdates() SubscribeOption {
+	return func(s *subscription) {
+		s.noTicker = true
+	}
+}
+
+// Subscribe registers fn to be called like OnUpdate, but only when one of the
+// counters selected by mask changed since the last call to fn. This includes
+// the final update in Done. For example, a view which only shows the number
+// of files and dirs uses FieldFiles|FieldDirs and is not redrawn when only
+// bytes are reported.
+func (p *Progress) Subscribe(fn ProgressFunc, mask StatField, opts ...SubscribeOption) {
+	if p == nil {
+		return
+	}
+
+	sub := &subscription{fn: fn, mask: mask}
+	for _, opt := range opts {
+		opt(sub)
+	}
+
+	p.fnM.Lock()
+	p.subscriptions = append(p.subscriptions, sub)
+	p.fnM.Unlock()
+}
+
+// notifySubscribers calls the subscriptions interested in the changes of cur.
+// The caller must hold fnM.
+func (p *Progress) notifySubscribers(cur Stat, runtime time.Duration, ticker bool) {
+	for _, sub := range p.subscriptions {
+		if ticker && sub.noTicker {
+			continue
+		}
+		if changedFields(sub.last, cur)&sub.mask == 0 {
+			continue
+		}
+
+		sub.last = cur
+		sub.fn(cur, runtime, ticker)
+	}
+}
@@ -0,0 +1,98 @@
+package restic
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestProgressSubscribeMask(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+
+	var calls []Stat
+	p.Subscribe(func(s Stat, d time.Duration, ticker bool) {
+		calls = append(calls, s)
+	}, FieldFiles|FieldDirs)
+
+	var all int
+	p.Subscribe(func(s Stat, d time.Duration, ticker bool) {
+		all++
+	}, FieldAll)
+
+	p.Start()
+
+	report := func(s Stat) {
+		clock.Advance(time.Second)
+		p.Report(s)
+	}
+
+	report(Stat{Bytes: 100})
+	report(Stat{Files: 1, Bytes: 100})
+	report(Stat{Bytes: 100})
+	report(Stat{Bytes: 100, StoredBytes: 50})
+	report(Stat{Dirs: 1})
+	p.Done()
+
+	want := []Stat{
+		{Files: 1, Bytes: 200},
+		{Files: 1, Dirs: 1, Bytes: 400, StoredBytes: 50},
+	}
+	if len(calls) != len(want) {
+		t.Fatalf("want %d calls, got %d: %v", len(want), len(calls), calls)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Errorf("call %d: want %v, got %v", i, want[i], calls[i])
+		}
+	}
+
+	// the final update in Done did not change anything
+	if all != 5 {
+		t.Errorf("expected 5 calls for all fields, got %d", all)
+	}
+}
+
+func TestProgressSubscribeWithoutTickerUpdates(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+
+	var all, reportsOnly []bool
+	p.Subscribe(func(s Stat, d time.Duration, ticker bool) {
+		all = append(all, ticker)
+	}, FieldAll)
+	p.Subscribe(func(s Stat, d time.Duration, ticker bool) {
+		reportsOnly = append(reportsOnly, ticker)
+	}, FieldAll, WithoutTickerUpdates())
+
+	p.Start()
+	clock.Advance(time.Second)
+	p.Report(Stat{Files: 1})
+	// this report is throttled, so only the tick delivers it
+	p.Report(Stat{Files: 1})
+	p.tick()
+	clock.Advance(time.Second)
+	p.Report(Stat{Files: 1})
+	p.Done()
+
+	if want := []bool{false, true, false}; !reflect.DeepEqual(all, want) {
+		t.Errorf("wrong calls for all updates, want %v, got %v", want, all)
+	}
+	if want := []bool{false, false}; !reflect.DeepEqual(reportsOnly, want) {
+		t.Errorf("wrong calls for reports only, want %v, got %v", want, reportsOnly)
+	}
+}
+
+func TestChangedFields(t *testing.T) {
+	a := Stat{Files: 1, Bytes: 10, Skipped: 2}
+	b := Stat{Files: 1, Bytes: 20, Errors: 1, Skipped: 3}
+
+	want := FieldBytes | FieldErrors | FieldSkipped
+	if got := changedFields(a, b); got != want {
+		t.Errorf("want %b, got %b", want, got)
+	}
+
+	if got := changedFields(a, a); got != 0 {
+		t.Errorf("expected no changes, got %b", got)
+	}
+}
@@ -0,0 +1,224 @@
+package restic
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// clearLine moves the cursor to the start of the line and clears it.
+const clearLine = "\r\x1b[2K"
+
+// barWidth is the number of characters of the progress bar.
+const barWidth = 20
+
+// maxPercentDrop is the most the percentage shown by the bar goes down per
+// update when the total grows faster than the progress.
+const maxPercentDrop = 2.0
+
+// spinnerFrames are shown in turn for operations without a total.
+var spinnerFrames = []string{"|", "/", "-", `\`}
+
+// indeterminateBlock moves back and forth in the bar of operations without a
+// total, see WithIndeterminateBar.
+const indeterminateBlock = "<=>"
+
+// TerminalReporter renders the state of a Progress as a single status line
+// which is redrawn on every update. When a total is set, a bar with the
+// percentage done is shown. Otherwise the operation is indeterminate and an
+// animated spinner with the throughput is shown instead, it advances one
+// frame per update. Once a total is set during the run, the next update
+// switches to the bar with the percentage. The total may grow while
+// the operation runs, the percentage shown then goes down gradually instead
+// of jumping backward. On Done the line is cleared.
+type TerminalReporter struct {
+	p *Progress
+	w io.Writer
+
+	// bar is set if an indeterminate bar is shown instead of the spinner
+	bar bool
+
+	m     sync.Mutex
+	frame int
+	// shown is the percentage drawn last, negative if none was drawn
+	shown float64
+}
+
+// TerminalOption configures a TerminalReporter.
+type TerminalOption func(r *TerminalReporter)
+
+// WithIndeterminateBar shows a bar with a block moving back and forth instead
+// of the spinner for operations without a total. The block advances one step
+// each time the ticker fires, not per report, so bursts of reports don't
+// speed it up.
+func WithIndeterminateBar() TerminalOption {
+	return func(r *TerminalReporter) {
+		r.bar = true
+	}
+}
+
+// NewTerminalReporter attaches a TerminalReporter writing to w to p,
+// previously configured OnUpdate and OnDone functions are still called. It
+// must be called before Start(). For a nil p, nothing is written.
+func NewTerminalReporter(p *Progress, w io.Writer, opts ...TerminalOption) *TerminalReporter {
+	r := &TerminalReporter{p: p, w: w, shown: -1}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if p == nil {
+		return r
+	}
+
+	onUpdate, onDone := p.OnUpdate, p.OnDone
+	p.OnUpdate = func(s Stat, d time.Duration, ticker bool) {
+		if onUpdate != nil {
+			onUpdate(s, d, ticker)
+		}
+		r.update(s, d, ticker)
+	}
+	p.OnDone = func(s Stat, d time.Duration, ticker bool) {
+		r.done()
+		if onDone != nil {
+			onDone(s, d, ticker)
+		}
+	}
+
+	return r
+}
+
+func (r *TerminalReporter) update(s Stat, d time.Duration, ticker bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	line := r.render(s, d, r.p.Total(), ticker)
+	_, _ = io.WriteString(r.w, clearLine+line)
+}
+
+func (r *TerminalReporter) done() {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.frame = 0
+	r.shown = -1
+	_, _ = io.WriteString(r.w, clearLine)
+}
+
+// render returns the status line for s after d. The spinner advances on every
+// call, the indeterminate bar only if ticker is set. The caller must hold m.
+func (r *TerminalReporter) render(s Stat, d time.Duration, total Stat, ticker bool) string {
+	counts := fmt.Sprintf("%v, %v", pluralize(s.Files, "file"), pluralize(s.Dirs, "dir"))
+
+	if total.Bytes == 0 {
+		var indicator string
+		if r.bar {
+			if ticker {
+				r.frame++
+			}
+			indicator = "[" + indeterminateBar(r.frame) + "]"
+		} else {
+			indicator = spinnerFrames[r.frame%len(spinnerFrames)]
+			r.frame++
+		}
+
+		var rate float64
+		if d > 0 {
+			rate = float64(s.Bytes) / d.Seconds()
+		}
+
+		return fmt.Sprintf("[%s] %s %s, %v, %v",
+			formatDuration(d), indicator, counts, FormatBytes(s.Bytes), FormatRate(rate, false))
+	}
+
+	pct := r.smoothPercent(percent(s.Bytes, total.Bytes))
+	filled := int(pct / 100 * barWidth)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled)
+
+	return fmt.Sprintf("[%s] [%s] %6.2f%% %s, %v / %v",
+		formatDuration(d), bar, pct, counts, FormatBytes(s.Bytes), FormatBytes(total.Bytes))
+}
+
+// indeterminateBar returns the bar for operations without a total in the
+// given frame. The block moves one character per frame and bounces back at
+// both ends.
+func indeterminateBar(frame int) string {
+	span := barWidth - len(indeterminateBlock)
+	pos := frame % (2 * span)
+	if pos > span {
+		pos = 2*span - pos
+	}
+
+	return strings.Repeat(" ", pos) + indeterminateBlock + strings.Repeat(" ", span-pos)
+}
+
+// smoothPercent returns the percentage to show for pct, limiting how far it
+// goes down compared to the last one shown. The caller must hold m.
+func (r *TerminalReporter) smoothPercent(pct float64) float64 {
+	if r.shown >= 0 && pct < r.shown-maxPercentDrop {
+		pct = r.shown - maxPercentDrop
+	}
+	r.shown = pct
+	return pct
+}
+
+// ellipsis is appended to lines which were shortened by RenderLine.
+const ellipsis = "…"
+
+// RenderLine returns a status line with the runtime, the percentage done if a
+// total is set, the counts and the rate, for example
+// "[0:05] 25.00% 1 file, 0 dirs, 1.50 MiB / 6.00 MiB, 300.0 KiB/s". It is
+// at most width runes long, a longer line is cut off and ends with an
+// ellipsis. The line contains no escape sequences, so it can be embedded in
+// a terminal UI as is.
+func (p *Progress) RenderLine(width int) string {
+	if p == nil {
+		return ""
+	}
+
+	snap := p.Snapshot()
+	s, d := snap.Stat, snap.Runtime
+	total := p.Total()
+
+	var rate float64
+	if d > 0 {
+		rate = float64(s.Bytes) / d.Seconds()
+	}
+
+	counts := fmt.Sprintf("%v, %v", pluralize(s.Files, "file"), pluralize(s.Dirs, "dir"))
+
+	var line string
+	if total.Bytes == 0 {
+		line = fmt.Sprintf("[%s] %s, %v, %v",
+			formatDuration(d), counts, FormatBytes(s.Bytes), FormatRate(rate, false))
+	} else {
+		line = fmt.Sprintf("[%s] %.2f%% %s, %v / %v, %v",
+			formatDuration(d), percent(s.Bytes, total.Bytes), counts,
+			FormatBytes(s.Bytes), FormatBytes(total.Bytes), FormatRate(rate, false))
+	}
+
+	return truncateLine(line, width)
+}
+
+// truncateLine shortens line to at most width runes. If it needs to be cut
+// off, the last rune is replaced by an ellipsis.
+func truncateLine(line string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+
+	if utf8.RuneCountInString(line) <= width {
+		return line
+	}
+
+	// keep width-1 runes and the ellipsis
+	n := 0
+	for i := range line {
+		if n == width-1 {
+			return line[:i] + ellipsis
+		}
+		n++
+	}
+	return line
+}
@@ -0,0 +1,231 @@
+package restic
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// emissions splits the output of a TerminalReporter into the lines drawn.
+func emissions(buf *bytes.Buffer) []string {
+	return strings.Split(buf.String(), clearLine)[1:]
+}
+
+func TestTerminalReporterSpinner(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+
+	buf := bytes.NewBuffer(nil)
+	NewTerminalReporter(p, buf)
+
+	p.Start()
+	for i := 0; i < 5; i++ {
+		clock.Advance(time.Second)
+		p.Report(Stat{Files: 1, Bytes: 2048})
+	}
+
+	lines := emissions(buf)
+	if len(lines) != 5 {
+		t.Fatalf("expected 5 emissions, got %d: %q", len(lines), lines)
+	}
+
+	wantFrames := []string{"|", "/", "-", `\`, "|"}
+	for i, line := range lines {
+		if !strings.HasPrefix(line[7:], wantFrames[i]+" ") {
+			t.Errorf("emission %d: expected spinner frame %q, got %q", i, wantFrames[i], line)
+		}
+	}
+
+	want := "[0:05] | 5 files, 0 dirs, 10.0 KiB, 2.0 KiB/s"
+	if lines[4] != want {
+		t.Errorf("wrong status line, want %q, got %q", want, lines[4])
+	}
+
+	p.Done()
+
+	// the final update is drawn, then the line is cleared
+	if !strings.HasSuffix(buf.String(), clearLine) {
+		t.Errorf("line not cleared on Done: %q", buf.String())
+	}
+}
+
+func TestTerminalReporterIndeterminate(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+
+	buf := bytes.NewBuffer(nil)
+	NewTerminalReporter(p, buf, WithIndeterminateBar())
+
+	p.Start()
+	for i := 0; i < 4; i++ {
+		clock.Advance(time.Second)
+		p.Report(Stat{Files: 1, Bytes: 2048})
+		p.tick()
+	}
+
+	lines := emissions(buf)
+	if len(lines) != 8 {
+		t.Fatalf("expected 8 emissions, got %d: %q", len(lines), lines)
+	}
+
+	wantBars := []string{
+		"<=>                 ", " <=>                ",
+		" <=>                ", "  <=>               ",
+		"  <=>               ", "   <=>              ",
+		"   <=>              ", "    <=>             ",
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line[7:], "["+wantBars[i]+"] ") {
+			t.Errorf("emission %d: expected bar %q, got %q", i, wantBars[i], line)
+		}
+	}
+
+	want := "[0:04] [    <=>             ] 4 files, 0 dirs, 8.0 KiB, 2.0 KiB/s"
+	if lines[7] != want {
+		t.Errorf("wrong status line, want %q, got %q", want, lines[7])
+	}
+
+	// the total is known now, so the percentage is shown
+	p.SetTotal(Stat{Bytes: 32 << 10})
+	p.tick()
+
+	lines = emissions(buf)
+	want = "[0:04] [=====               ]  25.00% 4 files, 0 dirs, 8.0 KiB / 32.0 KiB"
+	if lines[len(lines)-1] != want {
+		t.Errorf("wrong status line after SetTotal, want %q, got %q", want, lines[len(lines)-1])
+	}
+
+	p.Done()
+
+	// the final update is drawn, then the line is cleared
+	if !strings.HasSuffix(buf.String(), clearLine) {
+		t.Errorf("line not cleared on Done: %q", buf.String())
+	}
+}
+
+func TestIndeterminateBar(t *testing.T) {
+	span := barWidth - len(indeterminateBlock)
+
+	for frame := 0; frame < 4*span; frame++ {
+		bar := indeterminateBar(frame)
+		if len(bar) != barWidth {
+			t.Fatalf("frame %d: bar %q has wrong width", frame, bar)
+		}
+		if strings.Count(bar, indeterminateBlock) != 1 {
+			t.Fatalf("frame %d: no block in bar %q", frame, bar)
+		}
+	}
+
+	if bar := indeterminateBar(span); !strings.HasSuffix(bar, indeterminateBlock) {
+		t.Errorf("block not at the end in frame %d: %q", span, bar)
+	}
+	if bar := indeterminateBar(span + 1); bar != indeterminateBar(span-1) {
+		t.Errorf("block does not bounce back: %q", bar)
+	}
+	if bar := indeterminateBar(2 * span); bar != indeterminateBar(0) {
+		t.Errorf("animation does not repeat: %q", bar)
+	}
+}
+
+func TestTerminalReporterBar(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+	p.SetTotal(Stat{Bytes: 6 << 20})
+
+	buf := bytes.NewBuffer(nil)
+	NewTerminalReporter(p, buf)
+
+	p.Start()
+	clock.Advance(time.Second)
+	p.Report(Stat{Files: 1, Bytes: 3 << 19})
+	p.Done()
+
+	lines := emissions(buf)
+	want := "[0:01] [=====               ]  25.00% 1 file, 0 dirs, 1.50 MiB / 6.00 MiB"
+	if lines[0] != want {
+		t.Errorf("wrong status line, want %q, got %q", want, lines[0])
+	}
+}
+
+func TestTerminalReporterGrowingTotal(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+	p.SetTotal(Stat{Bytes: 100})
+
+	buf := bytes.NewBuffer(nil)
+	r := NewTerminalReporter(p, buf)
+
+	p.Start()
+	var shown []float64
+	for i, total := range []uint64{100, 200, 800, 1000, 4000, 4000, 4000} {
+		p.SetTotal(Stat{Bytes: total})
+		clock.Advance(time.Second)
+		if i < 3 {
+			p.Report(Stat{Bytes: 50})
+		} else {
+			p.Report(Stat{Bytes: 300})
+		}
+
+		r.m.Lock()
+		shown = append(shown, r.shown)
+		r.m.Unlock()
+	}
+	p.Done()
+
+	for i := 1; i < len(shown); i++ {
+		if shown[i] < shown[i-1]-maxPercentDrop {
+			t.Errorf("percentage dropped from %.2f to %.2f", shown[i-1], shown[i])
+		}
+	}
+
+	// the shown percentage converges to the real one
+	if want := percent(p.Current().Bytes, p.Total().Bytes); shown[len(shown)-1] < want {
+		t.Errorf("percentage %.2f below the real one %.2f", shown[len(shown)-1], want)
+	}
+}
+
+func TestProgressRenderLine(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+	p.SetTotal(Stat{Bytes: 6 << 20})
+	p.Start()
+	defer p.Done()
+
+	clock.Advance(5 * time.Second)
+	p.Report(Stat{Files: 1, Bytes: 3 << 19})
+
+	want := "[0:05] 25.00% 1 file, 0 dirs, 1.50 MiB / 6.00 MiB, 307.2 KiB/s"
+	if got := p.RenderLine(100); got != want {
+		t.Errorf("wrong line, want %q, got %q", want, got)
+	}
+
+	if got := p.RenderLine(13); got != "[0:05] 25.00…" {
+		t.Errorf("wrong truncated line %q", got)
+	}
+}
+
+func TestTruncateLine(t *testing.T) {
+	line := "ä文件 files"
+
+	for width := 0; width <= 12; width++ {
+		got := truncateLine(line, width)
+		if !utf8.ValidString(got) {
+			t.Errorf("width %d: rune split in %q", width, got)
+		}
+		if n := utf8.RuneCountInString(got); n > width {
+			t.Errorf("width %d: line %q has %d runes", width, got, n)
+		}
+		if width < utf8.RuneCountInString(line) && width > 0 && !strings.HasSuffix(got, ellipsis) {
+			t.Errorf("width %d: no ellipsis in %q", width, got)
+		}
+	}
+
+	if got := truncateLine(line, 3); got != "ä文…" {
+		t.Errorf("wrong result %q", got)
+	}
+	if got := truncateLine(line, 9); got != line {
+		t.Errorf("line which fits was changed to %q", got)
+	}
+}
@@ -0,0 +1,2423 @@
+package restic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/errors"
+)
+
+// fakeClock is a Clock which only advances when told to. Its tickers fire
+// when Advance moves the time past their next tick.
+type fakeClock struct {
+	m       sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1460289341, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.now = c.now.Add(d)
+	for _, t := range c.tickers {
+		for !t.stopped && !t.next.After(c.now) {
+			// like time.Ticker, drop ticks for slow receivers
+			select {
+			case t.c <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.d)
+		}
+	}
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	t := &fakeTicker{clock: c, c: make(chan time.Time, 1), d: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+type fakeTicker struct {
+	clock   *fakeClock
+	c       chan time.Time
+	d       time.Duration
+	next    time.Time
+	stopped bool
+}
+
+func (t *fakeTicker) Chan() <-chan time.Time { return t.c }
+
+func (t *fakeTicker) Stop() {
+	t.clock.m.Lock()
+	t.stopped = true
+	t.clock.m.Unlock()
+}
+
+// waitTick waits until ticks receives a value or fails the test after a
+// while.
+func waitTick(t testing.TB, ticks <-chan Stat) Stat {
+	select {
+	case s := <-ticks:
+		return s
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for a tick")
+		return Stat{}
+	}
+}
+
+// noTick fails the test if ticks receives a value within a short time.
+func noTick(t testing.TB, ticks <-chan Stat) {
+	select {
+	case <-ticks:
+		t.Fatal("unexpected tick")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestProgressNil(t *testing.T) {
+	var p *Progress
+
+	var tests = map[string]func(){
+		"CloneConfig":             func() { _ = p.CloneConfig() },
+		"Start":                   func() { p.Start() },
+		"Reset":                   func() { p.Reset() },
+		"Report":                  func() { _ = p.Report(Stat{Files: 1}) },
+		"ReportStored":            func() { p.ReportStored(10, 5) },
+		"ReportBlobSizes":         func() { p.ReportBlobSizes(10, 5) },
+		"ReportSkipped":           func() { p.ReportSkipped(1) },
+		"ReportError":             func() { p.ReportError(errors.New("error")) },
+		"ReportFileStart":         func() { p.ReportFileStart() },
+		"ReportFileDone":          func() { p.ReportFileDone() },
+		"ActiveFiles":             func() { _ = p.ActiveFiles() },
+		"ReportDepth":             func() { p.ReportDepth(1) },
+		"MaxDepth":                func() { _ = p.MaxDepth() },
+		"Begin":                   func() { p.Begin().Rollback() },
+		"ReportFiles":             func() { p.ReportFiles(1, 10) },
+		"ReportDirs":              func() { p.ReportDirs(1) },
+		"ReportFast":              func() { p.ReportFast(Stat{Files: 1}) },
+		"Flush":                   func() { p.Flush() },
+		"LastActivity":            func() { _ = p.LastActivity() },
+		"IsStalled":               func() { _ = p.IsStalled(time.Second) },
+		"LastUpdateWasTick":       func() { _ = p.LastUpdateWasTick() },
+		"AverageCallbackDuration": func() { _ = p.AverageCallbackDuration() },
+		"SetInterval":             func() { _ = p.SetInterval(time.Second) },
+		"Interval":                func() { _ = p.Interval() },
+		"Done":                    func() { p.Done() },
+		"DoneWithReason":          func() { p.DoneWithReason(DoneError) },
+		"Wait":                    func() { _, _ = p.Wait() },
+		"Completed":               func() { _ = p.Completed() },
+		"Finalize":                func() { p.Finalize() },
+		"DoneReason":              func() { _ = p.DoneReason() },
+		"Name":                    func() { _ = p.Name() },
+		"StopSignal":              func() { _ = p.StopSignal() },
+		"Context":                 func() { _ = p.Context(context.Background()) },
+		"Tick":                    func() { p.Tick() },
+		"Elapsed":                 func() { _ = p.Elapsed() },
+		"SetTotal":                func() { p.SetTotal(Stat{Files: 1}) },
+		"AddTotal":                func() { p.AddTotal(Stat{Files: 1}) },
+		"Total":                   func() { _ = p.Total() },
+		"RemainingBytes":          func() { _, _ = p.RemainingBytes() },
+		"RemainingItems":          func() { _, _ = p.RemainingItems() },
+		"PercentDone":             func() { _ = p.PercentDone() },
+		"PercentDoneField":        func() { _, _ = p.PercentDoneField(func(s Stat) uint64 { return s.Files }) },
+		"PercentByFiles":          func() { _ = p.PercentByFiles() },
+		"PercentByBytes":          func() { _ = p.PercentByBytes() },
+		"Current":                 func() { _ = p.Current() },
+		"Summary":                 func() { _ = p.Summary() },
+		"History":                 func() { _ = p.History() },
+		"RateHistogram":           func() { _ = p.RateHistogram() },
+		"Items":                   func() { _ = p.Items() },
+		"ItemsPercent":            func() { _ = p.ItemsPercent() },
+		"ItemRate":                func() { _ = p.ItemRate() },
+		"ItemsPerSecond":          func() { _ = p.ItemsPerSecond() },
+		"SetCompletionModel":      func() { p.SetCompletionModel(1, 1) },
+		"RegisterMilestone":       func() { p.RegisterMilestone(FieldFiles, 10, func(uint64, Stat) {}) },
+		"AverageRate":             func() { _ = p.AverageRate() },
+		"ActiveRate":              func() { _ = p.ActiveRate() },
+		"SmoothedRate":            func() { _ = p.SmoothedRate() },
+		"ETA":                     func() { _ = p.ETA() },
+		"ETATime":                 func() { _ = p.ETATime() },
+		"ETASmoothed":             func() { _ = p.ETASmoothed() },
+		"ResetRateStats":          func() { p.ResetRateStats() },
+		"SetMinRate":              func() { p.SetMinRate(100, func() {}) },
+		"Subscribe":               func() { p.Subscribe(func(Stat, time.Duration, bool) {}, FieldAll) },
+		"RenderLine":              func() { _ = p.RenderLine(80) },
+		"Updates":                 func() { <-p.Updates() },
+		"SubscriberStats":         func() { _ = p.SubscriberStats() },
+		"LifetimeStat":            func() { _ = p.LifetimeStat() },
+		"Snapshot":                func() { _ = p.Snapshot() },
+
+		// functions which take a Progress
+		"AttachBar":           func() { AttachBar(p, &fakeBar{}) },
+		"NewTerminalReporter": func() { _ = NewTerminalReporter(p, ioutil.Discard) },
+		"NewProgressCSV":      func() { _ = NewProgressCSV(p, ioutil.Discard) },
+		"NewProgressRecorder": func() { _ = NewProgressRecorder(p) },
+		"Aggregator.Add":      func() { NewAggregator(nil).Add(p) },
+	}
+
+	// every exported method must be covered, so that new ones are checked
+	typ := reflect.TypeOf(p)
+	for i := 0; i < typ.NumMethod(); i++ {
+		if name := typ.Method(i).Name; tests[name] == nil {
+			t.Errorf("method %v is not tested with a nil Progress", name)
+		}
+	}
+
+	for name, fn := range tests {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("panic with a nil Progress: %v", r)
+				}
+			}()
+			fn()
+		})
+	}
+}
+
+// activeReporters returns the number of reporter goroutines running for p.
+func activeReporters(p *Progress) int {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	frame := fmt.Sprintf("(*Progress).reporter(%p", p)
+	count := 0
+	for _, g := range strings.Split(string(buf), "\n\n") {
+		if strings.Contains(g, frame) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestProgressRestartJoinsReporter(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock))
+	if err := p.SetInterval(time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	// a slow callback keeps the reporter busy when Done is called
+	p.OnUpdate = func(s Stat, d time.Duration, ticker bool) {
+		if ticker {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	stop := make(chan struct{})
+	advanced := make(chan struct{})
+	go func() {
+		defer close(advanced)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			clock.Advance(time.Millisecond)
+			runtime.Gosched()
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		p.Start()
+		if n := activeReporters(p); n > 1 {
+			t.Fatalf("cycle %d: %d reporter goroutines running", i, n)
+		}
+		p.Report(Stat{Files: 1})
+		p.Done()
+	}
+
+	close(stop)
+	<-advanced
+}
+
+func TestProgressManualTick(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithManualTick(), WithHistoryDuration(time.Minute))
+
+	var updates, ticks int
+	var last Stat
+	p.OnUpdate = func(s Stat, d time.Duration, ticker bool) {
+		updates++
+		if ticker {
+			ticks++
+		}
+		last = s
+	}
+
+	// not running yet
+	p.Tick()
+
+	p.Start()
+	for i := 0; i < 5; i++ {
+		clock.Advance(time.Second)
+		p.Report(Stat{Files: 1, Bytes: 100})
+		p.Report(Stat{Bytes: 100})
+	}
+
+	// reports do not call OnUpdate
+	if updates != 0 {
+		t.Fatalf("OnUpdate called %d times by Report", updates)
+	}
+
+	for i := 0; i < 3; i++ {
+		clock.Advance(time.Second)
+		p.Tick()
+	}
+	if updates != 3 || ticks != 3 {
+		t.Errorf("expected 3 ticks, got %d updates and %d ticks", updates, ticks)
+	}
+	if last.Files != 5 || last.Bytes != 1000 {
+		t.Errorf("wrong statistics in the last tick: %v", last)
+	}
+	if h := p.History(); len(h) != 3 {
+		t.Errorf("expected 3 samples, got %d", len(h))
+	}
+
+	p.Flush()
+	if updates != 4 || ticks != 3 {
+		t.Errorf("wrong counts after Flush: %d updates, %d ticks", updates, ticks)
+	}
+
+	p.Done()
+
+	// ticks after Done are ignored
+	p.Tick()
+	if updates != 4 || ticks != 3 {
+		t.Errorf("wrong counts after Done: %d updates, %d ticks", updates, ticks)
+	}
+}
+
+func TestProgressSkipEmptyFirstTick(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithManualTick(), WithSkipEmptyFirstTick())
+
+	var ticks []Stat
+	p.OnUpdate = func(s Stat, d time.Duration, ticker bool) {
+		if ticker {
+			ticks = append(ticks, s)
+		}
+	}
+
+	// the first tick fires before any Report and is suppressed
+	p.Start()
+	clock.Advance(time.Second)
+	p.Tick()
+	if len(ticks) != 0 {
+		t.Fatalf("first tick before any report was delivered: %v", ticks)
+	}
+
+	// later ticks are delivered, even without data
+	clock.Advance(time.Second)
+	p.Tick()
+	if len(ticks) != 1 || ticks[0] != (Stat{}) {
+		t.Fatalf("expected one empty tick, got %v", ticks)
+	}
+	p.Done()
+
+	// the suppression applies again to the next run, but not if data was
+	// reported before the first tick
+	ticks = nil
+	p.Start()
+	p.Report(Stat{Files: 1, Bytes: 100})
+	clock.Advance(time.Second)
+	p.Tick()
+	if len(ticks) != 1 || ticks[0].Bytes != 100 {
+		t.Fatalf("expected the first tick after a report, got %v", ticks)
+	}
+	p.Done()
+}
+
+func TestProgressEmptyFirstTickDefault(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithManualTick())
+
+	ticks := 0
+	p.OnUpdate = func(s Stat, d time.Duration, ticker bool) {
+		if ticker {
+			ticks++
+		}
+	}
+
+	p.Start()
+	clock.Advance(time.Second)
+	p.Tick()
+	p.Done()
+
+	if ticks != 1 {
+		t.Errorf("expected the first tick to be delivered by default, got %d ticks", ticks)
+	}
+}
+
+func TestProgressMaxDepth(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+	ch := p.Updates()
+	p.Start()
+
+	for _, d := range []int{1, 3, 2, 7, 0, 5, -1} {
+		p.ReportDepth(d)
+	}
+	if d := p.MaxDepth(); d != 7 {
+		t.Errorf("wrong max depth, want 7, got %d", d)
+	}
+	if s := p.Current(); s != (Stat{}) {
+		t.Errorf("ReportDepth changed the counters: %v", s)
+	}
+
+	snap := p.Snapshot()
+	if snap.MaxDepth != 7 {
+		t.Errorf("wrong max depth in the snapshot, want 7, got %d", snap.MaxDepth)
+	}
+	buf, err := json.Marshal(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(buf), `"max_depth":7`) {
+		t.Errorf("max depth missing from JSON: %s", buf)
+	}
+
+	p.Done()
+	if ev := <-ch; ev.MaxDepth != 7 {
+		t.Errorf("wrong max depth in the final event, want 7, got %d", ev.MaxDepth)
+	}
+
+	// the max depth is kept after Done, but reset for a new run
+	if d := p.MaxDepth(); d != 7 {
+		t.Errorf("wrong max depth after Done, want 7, got %d", d)
+	}
+	p.Start()
+	if d := p.MaxDepth(); d != 0 {
+		t.Errorf("max depth not reset for a new run, got %d", d)
+	}
+	p.Done()
+}
+
+func TestProgressActiveFiles(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+	p.Start()
+
+	p.ReportFileStart()
+	p.ReportFileStart()
+	p.Report(Stat{Bytes: 1 << 20})
+	if n := p.ActiveFiles(); n != 2 {
+		t.Errorf("wrong number of active files %d", n)
+	}
+
+	p.ReportFileDone()
+	p.Report(Stat{Files: 1})
+	if n := p.ActiveFiles(); n != 1 {
+		t.Errorf("wrong number of active files %d", n)
+	}
+	if s := p.Current(); s.Files != 1 {
+		t.Errorf("wrong number of files %d", s.Files)
+	}
+
+	p.ReportFileDone()
+	// more calls to ReportFileDone than to ReportFileStart are ignored
+	p.ReportFileDone()
+	if n := p.ActiveFiles(); n != 0 {
+		t.Errorf("wrong number of active files %d", n)
+	}
+
+	p.ReportFileStart()
+	p.Done()
+
+	// a new run starts without active files
+	p.Start()
+	defer p.Done()
+	if n := p.ActiveFiles(); n != 0 {
+		t.Errorf("active files not reset by Start: %d", n)
+	}
+}
+
+func TestProgressETATime(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+	p.Start()
+	defer p.Done()
+
+	// no total
+	clock.Advance(time.Second)
+	p.Report(Stat{Bytes: 100})
+	if eta := p.ETATime(); !eta.IsZero() {
+		t.Errorf("expected zero time without a total, got %v", eta)
+	}
+
+	p.Reset()
+	p.SetTotal(Stat{Bytes: 1000})
+	if eta := p.ETATime(); !eta.IsZero() {
+		t.Errorf("expected zero time before anything was processed, got %v", eta)
+	}
+
+	clock.Advance(time.Second)
+	p.Report(Stat{Bytes: 250})
+
+	// 250 bytes in 2 seconds, 750 bytes remaining
+	want := clock.Now().Add(6 * time.Second)
+	if eta := p.ETATime(); !eta.Equal(want) {
+		t.Errorf("wrong ETA time, want %v, got %v", want, eta)
+	}
+	if eta := p.ETATime(); !eta.Equal(clock.Now().Add(p.ETA())) {
+		t.Errorf("ETA time %v does not match ETA %v", eta, p.ETA())
+	}
+
+	// finished, so the operation is expected to end now
+	p.Report(Stat{Bytes: 750})
+	if eta := p.ETATime(); !eta.Equal(clock.Now()) {
+		t.Errorf("wrong ETA time when finished, want %v, got %v", clock.Now(), eta)
+	}
+}
+
+func TestProgressETATimeRealClock(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+	p.SetTotal(Stat{Bytes: 1000})
+	p.Start()
+	defer p.Done()
+
+	time.Sleep(10 * time.Millisecond)
+	p.Report(Stat{Bytes: 500})
+
+	eta := p.ETATime()
+	want := time.Now().Add(p.ETA())
+	if d := want.Sub(eta); d < -time.Second || d > time.Second {
+		t.Errorf("ETA time %v too far from %v", eta, want)
+	}
+}
+
+func TestProgressETASmoothed(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+	p.SetTotal(Stat{Bytes: 10000})
+	p.Start()
+	defer p.Done()
+
+	if eta := p.ETASmoothed(); eta != 0 {
+		t.Fatalf("expected zero ETA before any data, got %v", eta)
+	}
+
+	// slow start: 10 bytes per second
+	for i := 0; i < 10; i++ {
+		clock.Advance(time.Second)
+		p.Report(Stat{Bytes: 10})
+		p.tick()
+	}
+
+	// until there are enough samples both estimates use the average
+	if p.rateSamples < minRateSamples {
+		t.Fatalf("expected at least %d samples, got %d", minRateSamples, p.rateSamples)
+	}
+
+	// throughput increases to 1000 bytes per second
+	for i := 0; i < 5; i++ {
+		clock.Advance(time.Second)
+		p.Report(Stat{Bytes: 1000})
+		p.tick()
+	}
+
+	avg := p.ETA()
+	smoothed := p.ETASmoothed()
+
+	// 5100 bytes in 15s leaves 4900 bytes, at 340 bytes/s that's about 14s
+	if avg < 14*time.Second || avg > 15*time.Second {
+		t.Errorf("unexpected average ETA %v", avg)
+	}
+
+	if smoothed >= avg {
+		t.Errorf("smoothed ETA %v is not below the average ETA %v", smoothed, avg)
+	}
+
+	// the smoothed rate is close to 1000 bytes/s, which leaves about 5s
+	if smoothed < 4*time.Second || smoothed > 8*time.Second {
+		t.Errorf("unexpected smoothed ETA %v", smoothed)
+	}
+}
+
+func TestProgressETASmoothedFallback(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+	p.SetTotal(Stat{Bytes: 1000})
+	p.Start()
+	defer p.Done()
+
+	clock.Advance(time.Second)
+	p.Report(Stat{Bytes: 100})
+	p.tick()
+
+	if p.ETASmoothed() != p.ETA() {
+		t.Errorf("expected fallback to average ETA %v, got %v", p.ETA(), p.ETASmoothed())
+	}
+
+	if eta := p.ETA(); eta != 9*time.Second {
+		t.Errorf("unexpected ETA %v", eta)
+	}
+}
+
+func TestStatSub(t *testing.T) {
+	s := Stat{Files: 5, Bytes: 100}.Sub(Stat{Files: 2, Dirs: 1, Bytes: 200})
+	want := Stat{Files: 3}
+	if s != want {
+		t.Errorf("wrong result, want %v, got %v", want, s)
+	}
+}
+
+func TestStatAddSaturates(t *testing.T) {
+	s := Stat{Files: math.MaxUint64 - 1, Bytes: math.MaxUint64, Dirs: 5}
+	s.Add(Stat{Files: 3, Bytes: 1, Dirs: 5, Skipped: math.MaxUint64})
+
+	want := Stat{Files: math.MaxUint64, Bytes: math.MaxUint64, Dirs: 10, Skipped: math.MaxUint64}
+	if s != want {
+		t.Errorf("wrong result, want %v, got %v", want, s)
+	}
+
+	// exactly reaching the maximum is not an overflow
+	s = Stat{Blobs: math.MaxUint64 - 10}
+	s.Add(Stat{Blobs: 10})
+	if s.Blobs != math.MaxUint64 {
+		t.Errorf("wrong blobs %d", s.Blobs)
+	}
+}
+
+func TestStatStringCombineObjects(t *testing.T) {
+	s := Stat{Files: 2, Dirs: 1, Trees: 3, Blobs: 40, Bytes: 100}
+
+	want := "Stat(2 files, 1 dirs, 3 trees, 40 blobs, 0 errors, 100 B)"
+	if str := s.StringWithOptions(StatOptions{}); str != want || s.String() != want {
+		t.Errorf("wrong separate rendering, want %q, got %q", want, str)
+	}
+
+	want = "Stat(2 files, 1 dirs, 43 objects, 0 errors, 100 B)"
+	if str := s.StringWithOptions(StatOptions{CombineObjects: true}); str != want {
+		t.Errorf("wrong combined rendering, want %q, got %q", want, str)
+	}
+
+	// the number of objects saturates instead of wrapping around
+	s = Stat{Trees: math.MaxUint64, Blobs: 1}
+	want = "Stat(0 files, 0 dirs, 18446744073709551615 objects, 0 errors, 0 B)"
+	if str := s.StringWithOptions(StatOptions{CombineObjects: true}); str != want {
+		t.Errorf("wrong combined rendering, want %q, got %q", want, str)
+	}
+}
+
+func TestStatDiff(t *testing.T) {
+	a := Stat{Files: 5, Dirs: 1, Bytes: 100, Errors: 2, Skipped: 7}
+	b := Stat{Files: 2, Dirs: 1, Bytes: 300, Trees: 4, Skipped: 7}
+
+	want := SignedStat{Files: 3, Bytes: -200, Trees: -4, Errors: 2}
+	if d := a.Diff(b); d != want {
+		t.Errorf("wrong diff, want %+v, got %+v", want, d)
+	}
+
+	want = SignedStat{Files: -3, Bytes: 200, Trees: 4, Errors: -2}
+	if d := b.Diff(a); d != want {
+		t.Errorf("wrong reverse diff, want %+v, got %+v", want, d)
+	}
+
+	if d := a.Diff(a); d != (SignedStat{}) {
+		t.Errorf("expected zero diff, got %+v", d)
+	}
+}
+
+func TestStatDiffClamped(t *testing.T) {
+	var tests = []struct {
+		a, b uint64
+		want int64
+	}{
+		{math.MaxUint64, 0, math.MaxInt64},
+		{0, math.MaxUint64, math.MinInt64},
+		{math.MaxInt64, 0, math.MaxInt64},
+		{0, math.MaxInt64, -math.MaxInt64},
+		{0, 1 << 63, math.MinInt64},
+		{1 << 63, 0, math.MaxInt64},
+	}
+
+	for _, test := range tests {
+		if d := (Stat{Bytes: test.a}).Diff(Stat{Bytes: test.b}); d.Bytes != test.want {
+			t.Errorf("%d - %d: want %d, got %d", test.a, test.b, test.want, d.Bytes)
+		}
+	}
+}
+
+func TestProgressWithoutTicker(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+
+	var updates []Stat
+	p.OnUpdate = func(s Stat, d time.Duration, ticker bool) {
+		if ticker {
+			t.Errorf("unexpected ticker update")
+		}
+		updates = append(updates, s)
+	}
+
+	before := runtime.NumGoroutine()
+	p.Start()
+	if n := runtime.NumGoroutine(); n != before {
+		t.Fatalf("Start spawned %d goroutines", n-before)
+	}
+
+	clock.Advance(time.Second)
+	p.Report(Stat{Files: 1})
+	clock.Advance(time.Second)
+	p.Report(Stat{Files: 1})
+
+	// this report is throttled, but Flush delivers it anyway
+	p.Report(Stat{Files: 1})
+	p.Flush()
+	p.Done()
+
+	want := []Stat{{Files: 1}, {Files: 2}, {Files: 3}}
+	if !reflect.DeepEqual(updates, want) {
+		t.Errorf("wrong updates, want %v, got %v", want, updates)
+	}
+}
+
+func TestProgressStallTimeout(t *testing.T) {
+	clock := newFakeClock()
+
+	var calls []time.Time
+	p := NewProgress(WithClock(clock), WithoutTicker(), WithStallTimeout(5*time.Second, func(lastActivity time.Time) {
+		calls = append(calls, lastActivity)
+	}))
+	p.Start()
+	defer p.Done()
+
+	clock.Advance(time.Second)
+	p.Report(Stat{Bytes: 100})
+	lastReport := clock.Now()
+
+	for i := 0; i < 4; i++ {
+		clock.Advance(time.Second)
+		p.tick()
+	}
+	if len(calls) != 0 {
+		t.Fatalf("onStall called before the timeout was reached")
+	}
+
+	for i := 0; i < 5; i++ {
+		clock.Advance(time.Second)
+		p.tick()
+	}
+	if len(calls) != 1 {
+		t.Fatalf("expected onStall to be called once, got %d calls", len(calls))
+	}
+	if !calls[0].Equal(lastReport) {
+		t.Errorf("wrong last activity, want %v, got %v", lastReport, calls[0])
+	}
+
+	// reporting re-arms the stall detection
+	p.Report(Stat{Bytes: 100})
+	clock.Advance(6 * time.Second)
+	p.tick()
+	if len(calls) != 2 {
+		t.Fatalf("expected onStall to be called again after a new stall, got %d calls", len(calls))
+	}
+}
+
+func TestStatMax(t *testing.T) {
+	a := Stat{Files: 5, Dirs: 1, Bytes: 100, Trees: 0, Blobs: 7, Errors: 2}
+	b := Stat{Files: 2, Dirs: 3, Bytes: 200, Trees: 1, Blobs: 7, Errors: 0}
+
+	want := Stat{Files: 5, Dirs: 3, Bytes: 200, Trees: 1, Blobs: 7, Errors: 2}
+	if got := a.Max(b); got != want {
+		t.Errorf("wrong result, want %v, got %v", want, got)
+	}
+
+	if got := b.Max(a); got != want {
+		t.Errorf("Max is not symmetric, want %v, got %v", want, got)
+	}
+}
+
+func TestStatStoredBytes(t *testing.T) {
+	var s Stat
+	s.Add(Stat{Bytes: 300, StoredBytes: 100})
+	s.Add(Stat{Bytes: 100, StoredBytes: 100})
+
+	if s.StoredBytes != 200 {
+		t.Errorf("wrong stored bytes %v", s.StoredBytes)
+	}
+
+	if r := s.CompressionRatio(); r != 2 {
+		t.Errorf("wrong compression ratio %v", r)
+	}
+
+	if sub := s.Sub(Stat{StoredBytes: 50}); sub.StoredBytes != 150 {
+		t.Errorf("wrong stored bytes after Sub: %v", sub.StoredBytes)
+	}
+
+	want := "Stat(0 files, 0 dirs, 0 trees, 0 blobs, 0 errors, 400 B, 200 B stored)"
+	if str := s.String(); str != want {
+		t.Errorf("wrong string, want %q, got %q", want, str)
+	}
+}
+
+func TestStatCompressionRatioZero(t *testing.T) {
+	if r := (Stat{}).CompressionRatio(); r != 0 {
+		t.Errorf("expected zero ratio for empty stat, got %v", r)
+	}
+
+	if r := (Stat{Bytes: 100}).CompressionRatio(); r != 0 {
+		t.Errorf("expected zero ratio when nothing was stored, got %v", r)
+	}
+}
+
+func TestProgressReportBlobSizes(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+	p.Start()
+	p.ReportBlobSizes(1000, 250)
+	p.ReportBlobSizes(3000, 750)
+	p.ReportStored(0, 600)
+	p.Done()
+
+	cur := p.Current()
+	want := Stat{Bytes: 4000, CompressedBytes: 1000, StoredBytes: 600}
+	if cur != want {
+		t.Fatalf("wrong stats, want %v, got %v", want, cur)
+	}
+
+	// compression is measured without deduplication
+	if r := cur.CompressionRatio(); r != 4 {
+		t.Errorf("wrong compression ratio %v", r)
+	}
+
+	if sub := cur.Sub(Stat{CompressedBytes: 400}); sub.CompressedBytes != 600 {
+		t.Errorf("wrong compressed bytes after Sub: %v", sub.CompressedBytes)
+	}
+	if d := (Stat{}).Diff(cur); d.CompressedBytes != -1000 {
+		t.Errorf("wrong compressed bytes in Diff: %v", d.CompressedBytes)
+	}
+
+	str := "Stat(0 files, 0 dirs, 0 trees, 0 blobs, 0 errors, 3.9 KiB, 600 B stored, 1000 B compressed)"
+	if cur.String() != str {
+		t.Errorf("wrong string, want %q, got %q", str, cur.String())
+	}
+
+	buf, err := json.Marshal(cur)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(buf), `"compressed_bytes":1000`) {
+		t.Errorf("compressed bytes missing from JSON: %s", buf)
+	}
+	var decoded Stat
+	if err := json.Unmarshal(buf, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded != cur {
+		t.Errorf("JSON round trip failed, want %v, got %v", cur, decoded)
+	}
+}
+
+func TestProgressReportStored(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+	p.Start()
+	p.ReportStored(1000, 250)
+	p.ReportStored(1000, 250)
+	p.Done()
+
+	want := Stat{Bytes: 2000, StoredBytes: 500}
+	if cur := p.Current(); cur != want {
+		t.Errorf("wrong stats, want %v, got %v", want, cur)
+	}
+}
+
+func TestProgressElapsedBackwardClock(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+	rec := NewProgressRecorder(p)
+	p.SetTotal(Stat{Bytes: 1000})
+	p.Start()
+
+	clock.Advance(2 * time.Second)
+	p.Report(Stat{Bytes: 100})
+	p.tick()
+
+	// the clock jumps back to before the start
+	clock.Advance(-time.Minute)
+	p.tick()
+
+	if d := p.Elapsed(); d != 0 {
+		t.Errorf("expected elapsed to be clamped to zero, got %v", d)
+	}
+
+	if r := p.AverageRate(); r < 0 {
+		t.Errorf("negative average rate %v", r)
+	}
+
+	if eta := p.ETA(); eta < 0 {
+		t.Errorf("negative ETA %v", eta)
+	}
+
+	p.Done()
+
+	for _, call := range append(rec.Updates(), rec.DoneCalls()...) {
+		if call.Runtime < 0 {
+			t.Errorf("negative runtime %v passed to callback", call.Runtime)
+		}
+	}
+}
+
+// monotonicClock is a fakeClock whose wall clock can be changed without
+// affecting the elapsed time measured by Since.
+type monotonicClock struct {
+	*fakeClock
+	elapsed time.Duration
+}
+
+func (c *monotonicClock) Since(t time.Time) time.Duration { return c.elapsed }
+
+func TestProgressElapsedMonotonicClock(t *testing.T) {
+	clock := &monotonicClock{fakeClock: newFakeClock()}
+	p := NewProgress(WithClock(clock), WithoutTicker())
+	var runtime time.Duration
+	p.OnUpdate = func(s Stat, d time.Duration, ticker bool) {
+		runtime = d
+	}
+	p.Start()
+	defer p.Done()
+
+	// the wall clock jumps back, but the monotonic clock goes on
+	clock.Advance(-time.Hour)
+	clock.elapsed = 3 * time.Second
+	p.Report(Stat{Files: 1})
+
+	if d := p.Elapsed(); d != 3*time.Second {
+		t.Errorf("wrong elapsed time, want 3s, got %v", d)
+	}
+	if runtime != 3*time.Second {
+		t.Errorf("wrong runtime passed to OnUpdate, want 3s, got %v", runtime)
+	}
+	if d := p.Snapshot().Runtime; d != 3*time.Second {
+		t.Errorf("wrong runtime in Snapshot, want 3s, got %v", d)
+	}
+
+	var _ MonotonicClock = realClock{}
+}
+
+func TestProgressRateBackwardClock(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+	rec := NewProgressRecorder(p)
+	p.SetTotal(Stat{Bytes: 10000})
+	p.Start()
+
+	for i := 0; i < 3; i++ {
+		clock.Advance(time.Second)
+		p.Report(Stat{Bytes: 100})
+		p.tick()
+	}
+
+	// after jumping back, the rate is measured again from the new time
+	clock.Advance(-time.Hour)
+	p.tick()
+	updates := len(rec.Updates())
+
+	clock.Advance(time.Second)
+	p.Report(Stat{Bytes: 100})
+	p.tick()
+
+	if len(rec.Updates()) <= updates {
+		t.Error("no updates after the clock jumped back")
+	}
+
+	if r := p.SmoothedRate(); r <= 0 {
+		t.Errorf("expected a positive smoothed rate after the jump, got %v", r)
+	}
+	if eta := p.ETASmoothed(); eta < 0 {
+		t.Errorf("negative ETA %v", eta)
+	}
+	for _, sample := range p.History() {
+		if sample.Rate < 0 {
+			t.Errorf("negative rate %v in history", sample.Rate)
+		}
+	}
+	if d := p.AverageCallbackDuration(); d < 0 {
+		t.Errorf("negative callback duration %v", d)
+	}
+
+	p.Done()
+}
+
+func TestProgressResetNotRunning(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+
+	// before the first run
+	p.Reset()
+	if s := p.Current(); s != (Stat{}) {
+		t.Errorf("counters not zero: %v", s)
+	}
+
+	p.Start()
+	p.Report(Stat{Files: 2, Bytes: 100})
+	p.Done()
+
+	// after Done, before the Progress is reused
+	p.Reset()
+	if s := p.Current(); s != (Stat{}) {
+		t.Errorf("counters not zero after Reset: %v", s)
+	}
+
+	p.Start()
+	defer p.Done()
+	if s := p.Report(Stat{Files: 1}); s != (Stat{Files: 1}) {
+		t.Errorf("wrong counters after restarting: %v", s)
+	}
+}
+
+func TestProgressCompleted(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+	if ch := p.Completed(); ch != nil {
+		t.Error("channel returned before Start")
+	}
+
+	p.Start()
+	completed := p.Completed()
+
+	doneCalled := false
+	p.OnDone = func(s Stat, d time.Duration, ticker bool) {
+		if doneCalled {
+			return
+		}
+		select {
+		case <-completed:
+			t.Error("channel closed before OnDone returned")
+		default:
+		}
+		doneCalled = true
+	}
+
+	p.Report(Stat{Files: 1})
+	select {
+	case <-completed:
+		t.Fatal("channel closed before Done")
+	default:
+	}
+
+	p.Done()
+	select {
+	case <-completed:
+	default:
+		t.Fatal("channel not closed after Done")
+	}
+	if !doneCalled {
+		t.Error("OnDone was not called")
+	}
+
+	// the next run uses a new channel
+	p.Start()
+	defer p.Done()
+	select {
+	case <-p.Completed():
+		t.Error("channel of the new run is already closed")
+	default:
+	}
+}
+
+func TestProgressResetRateStats(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker(), WithHistoryDuration(time.Minute))
+	p.Start()
+	defer p.Done()
+
+	for i := 0; i < 5; i++ {
+		clock.Advance(time.Second)
+		p.Report(Stat{Files: 1, Bytes: 1000})
+		p.tick()
+	}
+
+	cur, elapsed := p.Current(), p.Elapsed()
+	p.ResetRateStats()
+
+	if c := p.Current(); c != cur {
+		t.Errorf("counters changed, want %v, got %v", cur, c)
+	}
+	if e := p.Elapsed(); e != elapsed {
+		t.Errorf("elapsed changed, want %v, got %v", elapsed, e)
+	}
+	if h := p.History(); len(h) != 0 {
+		t.Errorf("history not cleared: %v", h)
+	}
+
+	p.curM.Lock()
+	ema, samples := p.ema, p.rateSamples
+	p.curM.Unlock()
+	if ema != 0 || samples != 0 {
+		t.Errorf("smoothed rate not reset: ema %v, %d samples", ema, samples)
+	}
+
+	// the next phase is slower, only its bytes count for the new samples
+	for i := 0; i < 3; i++ {
+		clock.Advance(time.Second)
+		p.Report(Stat{Bytes: 100})
+		p.tick()
+	}
+	if r := p.SmoothedRate(); r != 100 {
+		t.Errorf("wrong smoothed rate after the reset: %v", r)
+	}
+	if r := p.AverageRate(); r != 5300.0/8 {
+		t.Errorf("average rate does not cover the whole run: %v", r)
+	}
+}
+
+func TestProgressLastActivity(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+	p.Start()
+	defer p.Done()
+
+	start := clock.Now()
+	if last := p.LastActivity(); !last.Equal(start) {
+		t.Errorf("want start time %v, got %v", start, last)
+	}
+
+	for i := 0; i < 3; i++ {
+		clock.Advance(time.Second)
+		p.Report(Stat{Dirs: 1})
+		if last := p.LastActivity(); !last.Equal(clock.Now()) {
+			t.Errorf("report %d: want %v, got %v", i, clock.Now(), last)
+		}
+	}
+
+	reported := clock.Now()
+	clock.Advance(time.Second)
+	p.tick()
+	if last := p.LastActivity(); !last.Equal(reported) {
+		t.Errorf("tick changed last activity to %v", last)
+	}
+}
+
+func TestProgressActiveRate(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker(), WithStallTimeout(5*time.Second, nil))
+	p.Start()
+	defer p.Done()
+
+	clock.Advance(2 * time.Second)
+	p.Report(Stat{Bytes: 1000})
+
+	// idle for 15 seconds beyond the stall timeout
+	clock.Advance(20 * time.Second)
+	p.Report(Stat{Bytes: 1000})
+
+	clock.Advance(2 * time.Second)
+	p.Report(Stat{Bytes: 1000})
+
+	// 3000 bytes in 24 seconds, of which 9 were active
+	if r := p.AverageRate(); r != 125 {
+		t.Errorf("wrong average rate %v", r)
+	}
+	if r := p.ActiveRate(); math.Abs(r-3000.0/9) > 1e-9 {
+		t.Errorf("wrong active rate %v, want %v", r, 3000.0/9)
+	}
+
+	// a gap below the stall timeout is active
+	clock.Advance(4 * time.Second)
+	p.Report(Stat{Bytes: 300})
+	if r := p.ActiveRate(); math.Abs(r-3300.0/13) > 1e-9 {
+		t.Errorf("wrong active rate %v, want %v", r, 3300.0/13)
+	}
+
+	// the current stall is idle as well
+	clock.Advance(10 * time.Second)
+	if r := p.ActiveRate(); math.Abs(r-3300.0/18) > 1e-9 {
+		t.Errorf("wrong active rate during a stall %v, want %v", r, 3300.0/18)
+	}
+	if p.ActiveRate() <= p.AverageRate() {
+		t.Errorf("active rate %v not above the average rate %v", p.ActiveRate(), p.AverageRate())
+	}
+}
+
+func TestProgressActiveRateWithoutStallTimeout(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+	p.Start()
+	defer p.Done()
+
+	clock.Advance(30 * time.Second)
+	p.Report(Stat{Bytes: 3000})
+
+	if r, avg := p.ActiveRate(), p.AverageRate(); r != avg {
+		t.Errorf("active rate %v differs from the average rate %v", r, avg)
+	}
+}
+
+func TestProgressMinRate(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker(), WithMinRateGrace(5*time.Second))
+
+	violations := 0
+	p.SetMinRate(1000, func() {
+		violations++
+	})
+
+	p.Start()
+	defer p.Done()
+
+	step := func(bytes uint64) {
+		clock.Advance(time.Second)
+		p.Report(Stat{Bytes: bytes})
+		p.tick()
+	}
+
+	// fast enough
+	for i := 0; i < 5; i++ {
+		step(2000)
+	}
+	if violations != 0 {
+		t.Fatalf("violation reported for a fast rate")
+	}
+
+	// the rate drops, the smoothed rate follows within a few ticks
+	for i := 0; i < 20 && violations == 0; i++ {
+		step(10)
+	}
+	if violations != 1 {
+		t.Fatalf("expected one violation, got %d", violations)
+	}
+
+	p.curM.Lock()
+	below := since(clock.Now(), p.belowMinRate)
+	p.curM.Unlock()
+	if below < 5*time.Second {
+		t.Errorf("violation reported after only %v below the minimum", below)
+	}
+
+	// still slow, no further calls
+	for i := 0; i < 10; i++ {
+		step(10)
+	}
+	if violations != 1 {
+		t.Errorf("violation reported again while still slow: %d", violations)
+	}
+}
+
+func TestProgressMinRateWithoutGrace(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+
+	violations := 0
+	p.SetMinRate(1000, func() {
+		violations++
+	})
+
+	p.Start()
+	defer p.Done()
+
+	clock.Advance(time.Second)
+	p.Report(Stat{Bytes: 10})
+	p.tick()
+	if violations != 1 {
+		t.Errorf("expected a violation on the first slow tick, got %d", violations)
+	}
+}
+
+func TestProgressZeroValue(t *testing.T) {
+	p := &Progress{}
+
+	if d := p.Elapsed(); d < 0 {
+		t.Errorf("negative elapsed before Start: %v", d)
+	}
+
+	var final Stat
+	p.OnDone = func(s Stat, d time.Duration, ticker bool) {
+		final = s
+	}
+
+	p.Start()
+	p.Report(Stat{Files: 1, Bytes: 100})
+	p.ReportDirs(1)
+	if s := p.Snapshot(); s.Stat.Files != 1 || s.Runtime < 0 {
+		t.Errorf("wrong snapshot %v", s)
+	}
+	p.Done()
+
+	if want := (Stat{Files: 1, Dirs: 1, Bytes: 100}); final != want {
+		t.Errorf("wrong final stats, want %v, got %v", want, final)
+	}
+
+	// the zero value can be started again
+	p.Start()
+	p.Report(Stat{Files: 1})
+	p.Done()
+}
+
+func TestProgressWaitNextRun(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+	p.Start()
+
+	waited := make(chan Stat)
+	go func() {
+		s, _ := p.Wait()
+		waited <- s
+	}()
+
+	p.Report(Stat{Files: 1})
+	// give the waiter time to block
+	time.Sleep(50 * time.Millisecond)
+	p.Done()
+
+	// the next run starts before the waiter has read the result
+	p.Start()
+	p.Report(Stat{Files: 10})
+	defer p.Done()
+
+	select {
+	case s := <-waited:
+		if s.Files != 1 {
+			t.Errorf("Wait returned the statistics of the next run: %v", s)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait did not return after Done")
+	}
+}
+
+func TestProgressWait(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+
+	if s, d := p.Wait(); s != (Stat{}) || d != 0 {
+		t.Errorf("Wait before Start returned %v, %v", s, d)
+	}
+
+	p.Start()
+
+	type result struct {
+		s Stat
+		d time.Duration
+	}
+	waited := make(chan result)
+	go func() {
+		s, d := p.Wait()
+		waited <- result{s, d}
+	}()
+
+	p.Report(Stat{Files: 2, Bytes: 100})
+	clock.Advance(3 * time.Second)
+
+	select {
+	case <-waited:
+		t.Fatal("Wait returned before Done")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	go p.Done()
+
+	select {
+	case res := <-waited:
+		want := result{Stat{Files: 2, Bytes: 100}, 3 * time.Second}
+		if res != want {
+			t.Errorf("wrong result, want %v, got %v", want, res)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait did not return after Done")
+	}
+
+	// after Done, Wait returns right away
+	if s, d := p.Wait(); s.Files != 2 || d != 3*time.Second {
+		t.Errorf("wrong result after Done: %v, %v", s, d)
+	}
+}
+
+func TestProgressFinalize(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+
+	var done []Stat
+	p.OnDone = func(s Stat, d time.Duration, ticker bool) {
+		done = append(done, s)
+	}
+
+	run := func(fail bool) error {
+		p.Start()
+		defer p.Finalize()
+
+		p.Report(Stat{Files: 1})
+		if fail {
+			return errors.New("early return")
+		}
+
+		p.Report(Stat{Files: 1})
+		p.Done()
+		return nil
+	}
+
+	if err := run(false); err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 1 || done[0].Files != 2 || p.DoneReason() != DoneCompleted {
+		t.Fatalf("wrong OnDone calls %v, reason %v", done, p.DoneReason())
+	}
+
+	done = nil
+	if err := run(true); err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(done) != 1 || done[0].Files != 1 {
+		t.Fatalf("wrong OnDone calls after an early return: %v", done)
+	}
+}
+
+func TestProgressFinalizePanic(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+
+	calls := 0
+	p.OnDone = func(s Stat, d time.Duration, ticker bool) {
+		calls++
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("wrong panic value %v", r)
+			}
+		}()
+
+		p.Start()
+		defer p.Finalize()
+
+		p.Report(Stat{Files: 1})
+		panic("boom")
+	}()
+
+	if calls != 1 {
+		t.Errorf("expected OnDone to be called once, got %d", calls)
+	}
+	if reason := p.DoneReason(); reason != DoneError {
+		t.Errorf("wrong reason %v", reason)
+	}
+}
+
+func TestProgressStopSignal(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+	p.Start()
+
+	stop := p.StopSignal()
+	select {
+	case <-stop:
+		t.Fatal("stop signal closed while running")
+	default:
+	}
+
+	p.Report(Stat{Files: 1})
+	select {
+	case <-stop:
+		t.Fatal("stop signal closed while running")
+	default:
+	}
+
+	p.Done()
+	select {
+	case <-stop:
+	default:
+		t.Fatal("stop signal not closed after Done")
+	}
+}
+
+func TestProgressContext(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+
+	if ctx := p.Context(context.Background()); ctx.Err() == nil {
+		t.Error("context not cancelled before Start")
+	}
+
+	p.Start()
+	ctx := p.Context(context.Background())
+	if ctx.Err() != nil {
+		t.Fatal("context cancelled while running")
+	}
+
+	p.Done()
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context not cancelled after Done")
+	}
+
+	// cancelling the parent also cancels the context
+	parent, cancel := context.WithCancel(context.Background())
+	p.Start()
+	defer p.Done()
+	ctx = p.Context(parent)
+	cancel()
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context not cancelled with its parent")
+	}
+}
+
+func TestProgressName(t *testing.T) {
+	p := NewProgress(WithName("repo1"))
+	if name := p.Name(); name != "repo1" {
+		t.Errorf("wrong name %q", name)
+	}
+
+	if name := NewProgress().Name(); name != "" {
+		t.Errorf("expected empty default name, got %q", name)
+	}
+}
+
+func TestStatValidate(t *testing.T) {
+	var tests = []struct {
+		s   Stat
+		err bool
+	}{
+		{Stat{}, false},
+		{Stat{Files: 3, Dirs: 1, Bytes: 100, StoredBytes: 100}, false},
+		{Stat{Bytes: 100, StoredBytes: 20}, false},
+		{Stat{Bytes: 100, StoredBytes: 101}, true},
+		{Stat{StoredBytes: 1}, true},
+	}
+
+	for _, test := range tests {
+		err := test.s.Validate()
+		if test.err && err == nil {
+			t.Errorf("expected error for %v, got nil", test.s)
+		}
+		if !test.err && err != nil {
+			t.Errorf("unexpected error for %v: %v", test.s, err)
+		}
+	}
+}
+
+func TestProgressAverageCallbackDuration(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+
+	delays := []time.Duration{100 * time.Millisecond, 300 * time.Millisecond}
+	calls := 0
+	p.OnUpdate = func(s Stat, d time.Duration, ticker bool) {
+		// a slow callback
+		clock.Advance(delays[calls%len(delays)])
+		calls++
+	}
+
+	if d := p.AverageCallbackDuration(); d != 0 {
+		t.Fatalf("expected zero before any callback, got %v", d)
+	}
+
+	p.Start()
+	for i := 0; i < 4; i++ {
+		clock.Advance(time.Second)
+		p.Report(Stat{Files: 1})
+	}
+	p.Done()
+
+	if calls != 4 {
+		t.Fatalf("expected 4 calls to OnUpdate, got %d", calls)
+	}
+
+	if d := p.AverageCallbackDuration(); d != 200*time.Millisecond {
+		t.Errorf("wrong average callback duration %v", d)
+	}
+}
+
+func TestProgressAddTotal(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+	p.SetTotal(Stat{Bytes: 100})
+	p.Start()
+	defer p.Done()
+
+	p.Report(Stat{Bytes: 50})
+	if pct := p.PercentDone(); pct != 50 {
+		t.Errorf("wrong percentage %v", pct)
+	}
+
+	p.AddTotal(Stat{Bytes: 100})
+	if pct := p.PercentDone(); pct != 25 {
+		t.Errorf("wrong percentage after AddTotal %v", pct)
+	}
+
+	if total := p.Total(); total.Bytes != 200 {
+		t.Errorf("wrong total %v", total)
+	}
+
+	p.Report(Stat{Bytes: 500})
+	if pct := p.PercentDone(); pct != 100 {
+		t.Errorf("percentage not clamped: %v", pct)
+	}
+}
+
+func TestProgressAddTotalConcurrent(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+	p.Start()
+	defer p.Done()
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			p.AddTotal(Stat{Bytes: 2})
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			p.Report(Stat{Bytes: 1})
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			if pct := p.PercentDone(); pct < 0 || pct > 100 {
+				t.Errorf("percentage out of range: %v", pct)
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+
+	if pct := p.PercentDone(); pct != 50 {
+		t.Errorf("wrong final percentage %v", pct)
+	}
+}
+
+func TestProgressPercentByField(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+	p.SetTotal(Stat{Files: 10, Dirs: 4, Bytes: 1000})
+	p.Start()
+	defer p.Done()
+
+	p.Report(Stat{Files: 8, Bytes: 600, Trees: 3})
+
+	if pct := p.PercentByFiles(); pct != 80 {
+		t.Errorf("wrong percentage by files %v", pct)
+	}
+	if pct := p.PercentByBytes(); pct != 60 {
+		t.Errorf("wrong percentage by bytes %v", pct)
+	}
+	if pct, ok := p.PercentDoneField(func(s Stat) uint64 { return s.Dirs }); pct != 0 || !ok {
+		t.Errorf("wrong percentage by dirs %v (ok %v)", pct, ok)
+	}
+
+	// no total for trees
+	if pct, ok := p.PercentDoneField(func(s Stat) uint64 { return s.Trees }); pct != 0 || ok {
+		t.Errorf("expected zero and not ok without a total, got %v (ok %v)", pct, ok)
+	}
+
+	p.Report(Stat{Files: 5})
+	if pct := p.PercentByFiles(); pct != 100 {
+		t.Errorf("percentage not clamped: %v", pct)
+	}
+}
+
+func TestProgressPartialTotal(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+	p.Start()
+	defer p.Done()
+
+	files := func(s Stat) uint64 { return s.Files }
+	bytes := func(s Stat) uint64 { return s.Bytes }
+
+	// only the size is known
+	p.SetTotal(Stat{Bytes: 1000})
+	p.Report(Stat{Files: 3, Bytes: 250})
+
+	if pct, ok := p.PercentDoneField(bytes); pct != 25 || !ok {
+		t.Errorf("wrong percentage by bytes %v (ok %v)", pct, ok)
+	}
+	if pct, ok := p.PercentDoneField(files); pct != 0 || ok {
+		t.Errorf("unknown total of files reported %v (ok %v)", pct, ok)
+	}
+
+	// only the number of files is known
+	p.SetTotal(Stat{Files: 4})
+	if pct, ok := p.PercentDoneField(files); pct != 75 || !ok {
+		t.Errorf("wrong percentage by files %v (ok %v)", pct, ok)
+	}
+	if _, ok := p.PercentDoneField(bytes); ok {
+		t.Error("unknown total of bytes reported as ok")
+	}
+
+	// a known total which was exceeded is clamped to 100%, not unknown
+	p.Report(Stat{Files: 3})
+	if pct, ok := p.PercentDoneField(files); pct != 100 || !ok {
+		t.Errorf("wrong percentage by files %v (ok %v)", pct, ok)
+	}
+}
+
+func TestProgressFilesOnlyTotal(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+	p.SetTotal(Stat{Files: 40})
+	p.Start()
+	defer p.Done()
+
+	if eta := p.ETA(); eta != 0 {
+		t.Errorf("expected no ETA before any file was processed, got %v", eta)
+	}
+
+	clock.Advance(10 * time.Second)
+	p.Report(Stat{Files: 10, Bytes: 1 << 20})
+
+	if pct := p.PercentDone(); pct != 25 {
+		t.Errorf("wrong percentage from files %v", pct)
+	}
+	if pct := p.PercentByBytes(); pct != 0 {
+		t.Errorf("expected no percentage by bytes, got %v", pct)
+	}
+
+	// 10 files took 10 seconds, so the remaining 30 take another 30
+	if eta := p.ETA(); eta != 30*time.Second {
+		t.Errorf("wrong ETA from files %v", eta)
+	}
+	if eta := p.ETASmoothed(); eta != 30*time.Second {
+		t.Errorf("wrong smoothed ETA from files %v", eta)
+	}
+
+	// once the bytes are known, they are used again
+	p.SetTotal(Stat{Files: 40, Bytes: 4 << 20})
+	if pct := p.PercentDone(); pct != 25 {
+		t.Errorf("wrong percentage from bytes %v", pct)
+	}
+	if eta := p.ETA(); eta != 30*time.Second {
+		t.Errorf("wrong ETA from bytes %v", eta)
+	}
+	p.Report(Stat{Bytes: 1 << 20})
+	if pct := p.PercentDone(); pct != 50 {
+		t.Errorf("wrong percentage from bytes %v", pct)
+	}
+}
+
+func TestProgressReportReturnsCumulative(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+	p.Start()
+	defer p.Done()
+
+	p.Report(Stat{Files: 1, Bytes: 10})
+	cur := p.Report(Stat{Files: 2, Bytes: 5})
+
+	want := Stat{Files: 3, Bytes: 15}
+	if cur != want {
+		t.Errorf("wrong cumulative stat, want %v, got %v", want, cur)
+	}
+
+	var nilProgress *Progress
+	if cur := nilProgress.Report(Stat{Files: 1}); cur != (Stat{}) {
+		t.Errorf("nil Progress returned %v", cur)
+	}
+}
+
+func TestProgressLastUpdateWasTick(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+	p.Start()
+
+	if p.LastUpdateWasTick() {
+		t.Fatal("LastUpdateWasTick is true before any update")
+	}
+
+	p.tick()
+	if !p.LastUpdateWasTick() {
+		t.Error("LastUpdateWasTick is false after a tick")
+	}
+
+	clock.Advance(time.Second)
+	p.Report(Stat{Files: 1})
+	if p.LastUpdateWasTick() {
+		t.Error("LastUpdateWasTick is true after a Report")
+	}
+
+	p.tick()
+	p.Done()
+	if p.LastUpdateWasTick() {
+		t.Error("LastUpdateWasTick is true after Done")
+	}
+}
+
+func TestProgressCloneConfig(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithName("template"), WithoutTicker(), WithHistoryDuration(time.Minute))
+	p.OnUpdate = func(s Stat, d time.Duration, ticker bool) {}
+	p.SetTotal(Stat{Bytes: 100})
+	p.Start()
+	p.Report(Stat{Files: 3})
+
+	clone := p.CloneConfig()
+	if clone.Name() != "template" || clone.clock != p.clock || !clone.noTicker || clone.historyDuration != time.Minute {
+		t.Errorf("configuration not copied")
+	}
+
+	if clone.running {
+		t.Errorf("clone is running")
+	}
+
+	if clone.OnUpdate != nil {
+		t.Errorf("callbacks were copied")
+	}
+
+	if clone.Total() != (Stat{}) {
+		t.Errorf("total was copied: %v", clone.Total())
+	}
+
+	clone.Start()
+	clone.Report(Stat{Files: 1})
+
+	if cur := clone.Current(); cur.Files != 1 {
+		t.Errorf("clone has wrong counters %v", cur)
+	}
+	if cur := p.Current(); cur.Files != 3 {
+		t.Errorf("original has wrong counters %v", cur)
+	}
+
+	clone.Done()
+	if !p.running {
+		t.Errorf("Done on the clone stopped the original")
+	}
+	p.Done()
+}
+
+func TestProgressCloneConfigConcurrent(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithAdaptiveInterval(time.Second, 8*time.Second))
+	p.Start()
+	defer p.Done()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i <= 100; i++ {
+			_ = p.SetInterval(time.Duration(i) * time.Second)
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		clone := p.CloneConfig()
+		if clone.maxInterval != 8*time.Second || clone.clock != p.clock {
+			t.Fatalf("configuration not copied")
+		}
+	}
+	<-done
+
+	if d := p.CloneConfig().d; d != 100*time.Second {
+		t.Errorf("clone has wrong interval %v", d)
+	}
+}
+
+func TestStatWeightedItems(t *testing.T) {
+	s := Stat{Files: 10, Dirs: 2, Trees: 4, Blobs: 100, Bytes: 12345}
+
+	if n := s.TotalItems(); n != 116 {
+		t.Errorf("wrong total items %v", n)
+	}
+
+	// the sum saturates like Add
+	huge := Stat{Files: math.MaxUint64 - 1, Dirs: 1, Blobs: 1}
+	if n := huge.TotalItems(); n != math.MaxUint64 {
+		t.Errorf("total items wrapped around: %v", n)
+	}
+	if n := FilesAndDirs(Stat{Files: math.MaxUint64, Dirs: 1}); n != math.MaxUint64 {
+		t.Errorf("files and dirs wrapped around: %v", n)
+	}
+
+	var tests = []struct {
+		w    StatWeights
+		want float64
+	}{
+		{StatWeights{}, 0},
+		{StatWeights{Files: 1, Dirs: 1, Trees: 1, Blobs: 1}, 116},
+		{StatWeights{Files: 1, Dirs: 1, Trees: 0.5, Blobs: 0.1}, 24},
+		{StatWeights{Files: 2}, 20},
+	}
+
+	for _, test := range tests {
+		if got := s.WeightedItems(test.w); got != test.want {
+			t.Errorf("wrong weighted items for %+v, want %v, got %v", test.w, test.want, got)
+		}
+	}
+}
+
+func TestProgressDonePanickingUpdate(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+
+	var errs []error
+	p.OnCallbackError = func(err error) {
+		errs = append(errs, err)
+	}
+
+	p.OnUpdate = func(s Stat, d time.Duration, ticker bool) {
+		panic("final update failed")
+	}
+
+	doneCalled := false
+	p.OnDone = func(s Stat, d time.Duration, ticker bool) {
+		doneCalled = true
+	}
+
+	p.Start()
+	p.Done()
+
+	if !doneCalled {
+		t.Error("OnDone was not called")
+	}
+
+	if len(errs) != 1 {
+		t.Fatalf("expected one error, got %v", errs)
+	}
+	if !strings.Contains(errs[0].Error(), "final update failed") {
+		t.Errorf("error does not contain the panic value: %v", errs[0])
+	}
+}
+
+func TestProgressReporterPanic(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock))
+	if err := p.SetInterval(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 10)
+	p.OnCallbackError = func(err error) {
+		errs <- err
+	}
+
+	ticks := make(chan Stat, 10)
+	p.OnUpdate = func(s Stat, d time.Duration, ticker bool) {
+		if ticker {
+			panic("ticker update failed")
+		}
+		ticks <- s
+	}
+
+	var final Stat
+	p.OnDone = func(s Stat, d time.Duration, ticker bool) {
+		final = s
+	}
+
+	p.Start()
+	clock.Advance(time.Second)
+
+	select {
+	case err := <-errs:
+		if !strings.Contains(err.Error(), "ticker update failed") {
+			t.Errorf("error does not contain the panic value: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("panic of the reporter was not passed to OnCallbackError")
+	}
+
+	// the ticker was stopped, but updates are still delivered
+	clock.Advance(time.Second)
+	p.Report(Stat{Files: 1})
+	p.Flush()
+	if s := waitTick(t, ticks); s.Files != 1 {
+		t.Errorf("wrong update after the panic: %v", s)
+	}
+
+	p.Done()
+	if final.Files != 1 {
+		t.Errorf("wrong final statistics %v", final)
+	}
+
+	select {
+	case err := <-errs:
+		t.Errorf("unexpected error %v", err)
+	default:
+	}
+}
+
+func TestProgressDonePanickingUpdateWithoutHook(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+	p.OnUpdate = func(s Stat, d time.Duration, ticker bool) {
+		panic("final update failed")
+	}
+
+	doneCalled := false
+	p.OnDone = func(s Stat, d time.Duration, ticker bool) {
+		doneCalled = true
+	}
+
+	p.Start()
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Error("panic was swallowed without an error hook")
+			}
+		}()
+		p.Done()
+	}()
+
+	if !doneCalled {
+		t.Error("OnDone was not called")
+	}
+}
+
+func TestProgressPanickingCallbackReleasesLock(t *testing.T) {
+	var tests = []struct {
+		name    string
+		setup   func(p *Progress)
+		trigger func(p *Progress)
+	}{
+		{
+			"OnFirstData",
+			func(p *Progress) { p.OnFirstData = func() { panic("first data") } },
+			func(p *Progress) { p.Report(Stat{Bytes: 1}) },
+		},
+		{
+			"OnError",
+			func(p *Progress) { p.OnError = func(error) { panic("error") } },
+			func(p *Progress) { p.ReportError(errors.New("failed")) },
+		},
+		{
+			"milestone",
+			func(p *Progress) {
+				p.RegisterMilestone(FieldFiles, 1, func(uint64, Stat) { panic("milestone") })
+			},
+			func(p *Progress) { p.Report(Stat{Files: 1}) },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := NewProgress(WithoutTicker())
+			test.setup(p)
+			p.Start()
+
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Error("callback did not panic")
+					}
+				}()
+				test.trigger(p)
+			}()
+
+			done := make(chan struct{})
+			go func() {
+				p.Flush()
+				p.Done()
+				close(done)
+			}()
+
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("deadlock after a panicking callback")
+			}
+		})
+	}
+}
+
+func TestProgressOnFirstData(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+
+	calls := 0
+	var cur Stat
+	p.OnFirstData = func() {
+		calls++
+		cur = p.Current()
+	}
+
+	p.Start()
+	p.Report(Stat{Files: 1})
+	p.Report(Stat{Dirs: 1})
+	if calls != 0 {
+		t.Fatalf("OnFirstData called before any bytes were reported")
+	}
+
+	p.Report(Stat{Files: 1, Bytes: 10})
+	p.Report(Stat{Bytes: 10})
+	if calls != 1 {
+		t.Fatalf("expected OnFirstData to be called once, got %d calls", calls)
+	}
+
+	if cur.Bytes != 10 {
+		t.Errorf("OnFirstData called before the bytes were accumulated: %v", cur)
+	}
+	p.Done()
+
+	// a new run fires it again
+	p.Start()
+	p.Report(Stat{Bytes: 1})
+	p.Done()
+	if calls != 2 {
+		t.Errorf("expected OnFirstData to be called again for a new run, got %d calls", calls)
+	}
+}
+
+func TestProgressAlignedTicks(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithAlignedTicks())
+	if err := p.SetInterval(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	ticks := make(chan time.Time, 10)
+	p.OnUpdate = func(s Stat, d time.Duration, ticker bool) {
+		if ticker {
+			ticks <- clock.Now()
+		}
+	}
+
+	// the fake clock starts one second after a multiple of ten seconds
+	p.Start()
+	defer p.Done()
+
+	clock.Advance(8 * time.Second)
+	select {
+	case now := <-ticks:
+		t.Fatalf("tick before the boundary at %v", now)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clock.Advance(time.Second)
+	select {
+	case now := <-ticks:
+		if !now.Equal(now.Truncate(10 * time.Second)) {
+			t.Errorf("first tick at %v is not on a boundary", now)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the first tick")
+	}
+
+	if d := alignDelay(clock.Now(), 10*time.Second); d != 10*time.Second {
+		t.Errorf("wrong delay on a boundary: %v", d)
+	}
+}
+
+// nextActiveTicker waits until the clock has exactly one ticker which is not
+// stopped and is not prev, it returns the ticker and the time of its next
+// tick.
+func nextActiveTicker(t testing.TB, c *fakeClock, prev *fakeTicker) (*fakeTicker, time.Time) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		c.m.Lock()
+		var active []*fakeTicker
+		for _, ticker := range c.tickers {
+			if !ticker.stopped {
+				active = append(active, ticker)
+			}
+		}
+		if len(active) == 1 && active[0] != prev {
+			next := active[0].next
+			c.m.Unlock()
+			return active[0], next
+		}
+		c.m.Unlock()
+
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatal("timeout waiting for a new ticker")
+	return nil, time.Time{}
+}
+
+func TestProgressAlignedTicksStayAligned(t *testing.T) {
+	clock := newFakeClock()
+	clock.Advance(300 * time.Millisecond)
+
+	p := NewProgress(WithClock(clock), WithAlignedTicks())
+	if err := p.SetInterval(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	ticks := make(chan Stat, 10)
+	p.OnUpdate = func(s Stat, d time.Duration, ticker bool) {
+		if ticker {
+			ticks <- s
+		}
+	}
+
+	p.Start()
+	defer p.Done()
+
+	// the ticks are delivered late by different amounts, each tick replaces
+	// the ticker by one for the next boundary
+	var ticker *fakeTicker
+	for _, late := range []time.Duration{0, 400 * time.Millisecond, 900 * time.Millisecond, 50 * time.Millisecond} {
+		var next time.Time
+		ticker, next = nextActiveTicker(t, clock, ticker)
+		if !next.Equal(next.Truncate(time.Second)) {
+			t.Fatalf("tick scheduled at %v, not on a second boundary", next)
+		}
+
+		clock.Advance(next.Sub(clock.Now()) + late)
+		waitTick(t, ticks)
+	}
+}
+
+func TestProgressSetInterval(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock))
+
+	if err := p.SetInterval(0); err == nil {
+		t.Fatal("SetInterval accepted a zero interval")
+	}
+
+	if err := p.SetInterval(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	ticks := make(chan Stat, 10)
+	p.OnUpdate = func(s Stat, d time.Duration, ticker bool) {
+		if ticker {
+			ticks <- s
+		}
+	}
+
+	p.Start()
+	defer p.Done()
+
+	p.Report(Stat{Files: 1})
+	clock.Advance(time.Second)
+	waitTick(t, ticks)
+	clock.Advance(time.Second)
+	waitTick(t, ticks)
+
+	if err := p.SetInterval(3 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(time.Second)
+	noTick(t, ticks)
+	clock.Advance(time.Second)
+	noTick(t, ticks)
+	clock.Advance(time.Second)
+	s := waitTick(t, ticks)
+
+	// the counters are not reset
+	if s.Files != 1 {
+		t.Errorf("counters changed by SetInterval: %v", s)
+	}
+
+	if d := p.Elapsed(); d != 5*time.Second {
+		t.Errorf("runtime changed by SetInterval: %v", d)
+	}
+}
+
+func TestProgressReportError(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+
+	var errs []error
+	p.OnError = func(err error) {
+		errs = append(errs, err)
+	}
+
+	p.Start()
+	p.Report(Stat{Files: 1})
+	p.ReportError(errors.New("first"))
+	p.ReportError(errors.New("second"))
+	p.Done()
+
+	if cur := p.Current(); cur.Errors != 2 {
+		t.Errorf("expected 2 errors, got %v", cur)
+	}
+
+	if len(errs) != 2 || errs[0].Error() != "first" || errs[1].Error() != "second" {
+		t.Errorf("wrong errors passed to OnError: %v", errs)
+	}
+
+	if !strings.Contains(p.Current().String(), "2 errors") {
+		t.Errorf("errors missing in %v", p.Current())
+	}
+}
+
+func TestProgressReportSkipped(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+	p.Start()
+	p.Report(Stat{Files: 2, Bytes: 100})
+	p.ReportSkipped(3)
+	p.ReportSkipped(4)
+	p.Done()
+
+	want := Stat{Files: 2, Bytes: 100, Skipped: 7}
+	cur := p.Current()
+	if cur != want {
+		t.Fatalf("wrong stats, want %v, got %v", want, cur)
+	}
+
+	str := "Stat(2 files, 0 dirs, 0 trees, 0 blobs, 0 errors, 100 B, 7 skipped)"
+	if cur.String() != str {
+		t.Errorf("wrong string, want %q, got %q", str, cur.String())
+	}
+
+	if sub := cur.Sub(Stat{Skipped: 5}); sub.Skipped != 2 {
+		t.Errorf("wrong skipped after Sub: %v", sub.Skipped)
+	}
+	if max := cur.Max(Stat{Skipped: 10}); max.Skipped != 10 {
+		t.Errorf("wrong skipped after Max: %v", max.Skipped)
+	}
+}
+
+func TestProgressReportBatch(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+	rec := NewProgressRecorder(p)
+
+	p.Start()
+	p.ReportFiles(10, 4096)
+	p.ReportDirs(3)
+	p.ReportFiles(5, 1024)
+
+	want := Stat{Files: 15, Dirs: 3, Bytes: 5120}
+	if cur := p.Current(); cur != want {
+		t.Errorf("wrong stats, want %v, got %v", want, cur)
+	}
+	p.Done()
+
+	// the first batch is reported in a single update
+	if updates := rec.Updates(); len(updates) == 0 || updates[0].Stat != (Stat{Files: 10, Bytes: 4096}) {
+		t.Errorf("wrong first update %v", updates)
+	}
+}
+
+func TestProgressIsStalled(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+	p.Start()
+	defer p.Done()
+
+	threshold := 10 * time.Second
+
+	clock.Advance(5 * time.Second)
+	if p.IsStalled(threshold) {
+		t.Fatal("stalled before the threshold was reached")
+	}
+
+	clock.Advance(5 * time.Second)
+	if !p.IsStalled(threshold) {
+		t.Fatal("not stalled after the threshold was reached")
+	}
+
+	// reports without bytes do not count as progress
+	p.Report(Stat{Dirs: 1})
+	if !p.IsStalled(threshold) {
+		t.Fatal("report without bytes cleared the stall")
+	}
+
+	p.Report(Stat{Bytes: 1})
+	if p.IsStalled(threshold) {
+		t.Fatal("report with bytes did not clear the stall")
+	}
+
+	clock.Advance(threshold)
+	if !p.IsStalled(threshold) {
+		t.Fatal("not stalled again after the threshold")
+	}
+}
+
+func BenchmarkProgressReportPerItem(b *testing.B) {
+	p := NewProgress(WithoutTicker())
+	p.Start()
+	defer p.Done()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		p.Report(Stat{Files: 1, Bytes: 512})
+	}
+}
+
+func BenchmarkProgressReportBatched(b *testing.B) {
+	p := NewProgress(WithoutTicker())
+	p.Start()
+	defer p.Done()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	var batch Stat
+	for i := 0; i < b.N; i++ {
+		batch.Add(Stat{Files: 1, Bytes: 512})
+		if batch.Files == 1000 {
+			p.Report(batch)
+			batch = Stat{}
+		}
+	}
+	p.Report(batch)
+}
+
+func TestStatClone(t *testing.T) {
+	s := Stat{Files: 1, Dirs: 2, Bytes: 3, StoredBytes: 2, Skipped: 4}
+	c := s.Clone()
+
+	s.Add(Stat{Files: 10, Bytes: 10})
+	s.Skipped = 0
+
+	want := Stat{Files: 1, Dirs: 2, Bytes: 3, StoredBytes: 2, Skipped: 4}
+	if c != want {
+		t.Errorf("copy was modified, want %v, got %v", want, c)
+	}
+}
+
+func TestStatCloneFieldKinds(t *testing.T) {
+	// Clone must be extended when a field of a reference type is added
+	typ := reflect.TypeOf(Stat{})
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		switch f.Type.Kind() {
+		case reflect.Map, reflect.Slice, reflect.Ptr, reflect.Interface, reflect.Chan, reflect.Func:
+			t.Errorf("field %v has reference type %v, update Stat.Clone and this test", f.Name, f.Type)
+		}
+	}
+}
+
+func TestProgressDoneReason(t *testing.T) {
+	var tests = []struct {
+		done   func(p *Progress)
+		reason DoneReason
+	}{
+		{func(p *Progress) { p.Done() }, DoneCompleted},
+		{func(p *Progress) { p.DoneWithReason(DoneCompleted) }, DoneCompleted},
+		{func(p *Progress) { p.DoneWithReason(DoneCancelled) }, DoneCancelled},
+		{func(p *Progress) { p.DoneWithReason(DoneError) }, DoneError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.reason.String(), func(t *testing.T) {
+			p := NewProgress(WithoutTicker())
+
+			var reason DoneReason = -1
+			p.OnDone = func(s Stat, d time.Duration, ticker bool) {
+				reason = p.DoneReason()
+			}
+
+			p.Start()
+			test.done(p)
+
+			if reason != test.reason {
+				t.Errorf("wrong reason in OnDone, want %v, got %v", test.reason, reason)
+			}
+
+			if p.DoneReason() != test.reason {
+				t.Errorf("wrong reason after Done, want %v, got %v", test.reason, p.DoneReason())
+			}
+		})
+	}
+}
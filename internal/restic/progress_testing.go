@@ -0,0 +1,68 @@
+package restic
+
+import (
+	"sync"
+	"time"
+)
+
+// ProgressCall is a single invocation of a ProgressFunc recorded by a
+// ProgressRecorder.
+type ProgressCall struct {
+	Stat    Stat
+	Runtime time.Duration
+	Ticker  bool
+}
+
+// ProgressRecorder records all calls to OnUpdate and OnDone of a Progress, so
+// that tests can make assertions about them. It is safe for concurrent use.
+type ProgressRecorder struct {
+	m       sync.Mutex
+	updates []ProgressCall
+	done    []ProgressCall
+}
+
+// NewProgressRecorder returns a recorder attached to p. It replaces the
+// OnUpdate and OnDone functions of p, so it must be called before Start().
+// For a nil p, the recorder records nothing.
+func NewProgressRecorder(p *Progress) *ProgressRecorder {
+	r := &ProgressRecorder{}
+	if p == nil {
+		return r
+	}
+	p.OnUpdate = func(s Stat, d time.Duration, ticker bool) {
+		r.m.Lock()
+		r.updates = append(r.updates, ProgressCall{Stat: s, Runtime: d, Ticker: ticker})
+		r.m.Unlock()
+	}
+	p.OnDone = func(s Stat, d time.Duration, ticker bool) {
+		r.m.Lock()
+		r.done = append(r.done, ProgressCall{Stat: s, Runtime: d, Ticker: ticker})
+		r.m.Unlock()
+	}
+	return r
+}
+
+// Updates returns a copy of all recorded calls to OnUpdate.
+func (r *ProgressRecorder) Updates() []ProgressCall {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return append([]ProgressCall(nil), r.updates...)
+}
+
+// DoneCalls returns a copy of all recorded calls to OnDone.
+func (r *ProgressRecorder) DoneCalls() []ProgressCall {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return append([]ProgressCall(nil), r.done...)
+}
+
+// LastUpdate returns the most recent call to OnUpdate. The bool is false if
+// OnUpdate has not been called yet.
+func (r *ProgressRecorder) LastUpdate() (ProgressCall, bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if len(r.updates) == 0 {
+		return ProgressCall{}, false
+	}
+	return r.updates[len(r.updates)-1], true
+}
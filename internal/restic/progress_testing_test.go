@@ -0,0 +1,51 @@
+package restic_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/restic/restic/internal/restic"
+)
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time { return c.now }
+
+func TestProgressRecorder(t *testing.T) {
+	clock := &testClock{now: time.Unix(1460289341, 0)}
+	p := restic.NewProgress(restic.WithClock(clock), restic.WithoutTicker())
+	rec := restic.NewProgressRecorder(p)
+
+	if _, ok := rec.LastUpdate(); ok {
+		t.Fatal("LastUpdate returned a call before Start")
+	}
+
+	p.Start()
+	clock.now = clock.now.Add(time.Second)
+	p.Report(restic.Stat{Files: 1, Bytes: 10})
+	clock.now = clock.now.Add(time.Second)
+	p.Report(restic.Stat{Files: 1, Bytes: 20})
+	p.Done()
+
+	updates := rec.Updates()
+	if len(updates) != 3 {
+		t.Fatalf("expected 3 updates, got %d: %v", len(updates), updates)
+	}
+
+	want := restic.ProgressCall{Stat: restic.Stat{Files: 1, Bytes: 10}, Runtime: time.Second}
+	if updates[0] != want {
+		t.Errorf("wrong first update, want %v, got %v", want, updates[0])
+	}
+
+	final := restic.ProgressCall{Stat: restic.Stat{Files: 2, Bytes: 30}, Runtime: 2 * time.Second}
+	if last, _ := rec.LastUpdate(); last != final {
+		t.Errorf("wrong last update, want %v, got %v", final, last)
+	}
+
+	done := rec.DoneCalls()
+	if len(done) != 1 || done[0] != final {
+		t.Errorf("wrong calls to OnDone, want [%v], got %v", final, done)
+	}
+}
@@ -0,0 +1,67 @@
+package restic
+
+import "sync"
+
+// Txn collects tentative reports to a Progress, for example of an operation
+// which is retried on failure. The reports are counted right away like with
+// Report, Rollback removes them again from the counters. A Txn is safe for
+// concurrent use, but must not be used from the callbacks of its Progress.
+type Txn struct {
+	p *Progress
+
+	m sync.Mutex
+	// pending is the sum of the statistics reported in the transaction
+	pending Stat
+	ended   bool
+}
+
+// Begin starts a transaction for reports which may have to be undone.
+func (p *Progress) Begin() *Txn {
+	return &Txn{p: p}
+}
+
+// Report reports s to the Progress like Progress.Report and records it in the
+// transaction. The accumulated statistics of the Progress are returned.
+// Reporting in a transaction which has ended panics.
+func (t *Txn) Report(s Stat) Stat {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	if t.ended {
+		panic("reporting in an ended Txn")
+	}
+
+	t.pending.Add(s)
+	return t.p.Report(s)
+}
+
+// Commit ends the transaction and keeps its reports. Calling Commit or
+// Rollback on an ended transaction does nothing.
+func (t *Txn) Commit() {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	t.ended = true
+	t.pending = Stat{}
+}
+
+// Rollback ends the transaction and subtracts its reports from the current
+// statistics of the Progress, the counters are never decreased below zero.
+// The statistics returned by LifetimeStat are not changed, as they never
+// decrease. No update is delivered, the next one shows the new statistics.
+func (t *Txn) Rollback() {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	if t.ended {
+		return
+	}
+	t.ended = true
+
+	if t.p != nil {
+		t.p.curM.Lock()
+		t.p.cur = t.p.curLocked().Sub(t.pending)
+		t.p.curM.Unlock()
+	}
+	t.pending = Stat{}
+}
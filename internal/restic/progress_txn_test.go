@@ -0,0 +1,75 @@
+package restic
+
+import "testing"
+
+func TestProgressTxn(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+	p.Start()
+
+	p.Report(Stat{Files: 1, Bytes: 100})
+
+	committed := p.Begin()
+	committed.Report(Stat{Files: 2, Bytes: 200})
+	committed.Report(Stat{Dirs: 1})
+	committed.Commit()
+
+	rolledBack := p.Begin()
+	rolledBack.Report(Stat{Files: 3, Bytes: 300})
+	if s := p.Current(); s.Files != 6 || s.Bytes != 600 {
+		t.Errorf("tentative reports not counted: %v", s)
+	}
+	rolledBack.Rollback()
+
+	want := Stat{Files: 3, Dirs: 1, Bytes: 300}
+	if s := p.Current(); s != want {
+		t.Errorf("wrong statistics after rollback, want %v, got %v", want, s)
+	}
+
+	// a rollback after a commit does nothing
+	committed.Rollback()
+	rolledBack.Rollback()
+	if s := p.Current(); s != want {
+		t.Errorf("wrong statistics after a second rollback, want %v, got %v", want, s)
+	}
+
+	// the lifetime statistics never decrease
+	if s := p.LifetimeStat(); s.Files != 6 || s.Bytes != 600 {
+		t.Errorf("wrong lifetime statistics: %v", s)
+	}
+
+	p.Done()
+}
+
+func TestProgressTxnRollbackAfterReset(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+	p.Start()
+
+	txn := p.Begin()
+	txn.Report(Stat{Files: 2, Bytes: 200})
+	p.Reset()
+	p.Report(Stat{Files: 1, Bytes: 100})
+
+	// the counters are not decreased below zero
+	txn.Rollback()
+	if s := p.Current(); s != (Stat{}) {
+		t.Errorf("wrong statistics after rollback, got %v", s)
+	}
+
+	p.Done()
+}
+
+func TestProgressTxnEnded(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+	p.Start()
+	defer p.Done()
+
+	txn := p.Begin()
+	txn.Commit()
+
+	defer func() {
+		if recover() == nil {
+			t.Error("reporting in an ended Txn did not panic")
+		}
+	}()
+	txn.Report(Stat{Files: 1})
+}
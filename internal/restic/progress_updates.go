@@ -0,0 +1,126 @@
+package restic
+
+import "time"
+
+// ProgressEvent is the state of a Progress at the time it was delivered.
+type ProgressEvent struct {
+	Stat    Stat          `json:"stat"`
+	Runtime time.Duration `json:"runtime"`
+
+	// Ticker is true if the event was sent by the periodic reporter, like
+	// the argument of ProgressFunc.
+	Ticker bool `json:"ticker,omitempty"`
+
+	// MaxDepth is the deepest directory level passed to ReportDepth so far,
+	// it is only a diagnostic and zero if ReportDepth was not called.
+	MaxDepth int `json:"max_depth,omitempty"`
+
+	// Seq numbers the events sent to the channels returned by Updates,
+	// starting at one. It increases by one for each event, so a gap
+	// between two events received shows how many were dropped because
+	// the consumer was too slow. It is zero for events which were not
+	// sent to a channel, like the one returned by Snapshot.
+	Seq uint64 `json:"seq,omitempty"`
+}
+
+// Updates returns a channel which receives a ProgressEvent whenever OnUpdate
+// would be called. When the consumer is slower than the events arrive, only
+// the latest one is kept, so Report never blocks on the channel. After Done,
+// a final event is sent and the channel is closed. Updates must be called
+// before Done.
+func (p *Progress) Updates() <-chan ProgressEvent {
+	ch := make(chan ProgressEvent, 1)
+	if p == nil {
+		close(ch)
+		return ch
+	}
+
+	p.fnM.Lock()
+	p.updates = append(p.updates, &subscriber{ch: ch})
+	p.fnM.Unlock()
+
+	return ch
+}
+
+// subscriber is a channel returned by Updates together with its delivery
+// counters.
+type subscriber struct {
+	ch chan ProgressEvent
+	SubscriberStats
+}
+
+// SubscriberStats counts the updates for one channel returned by Updates.
+// Sent is the number of updates put into the channel, Dropped the number of
+// those which were replaced by a newer one before the consumer received them.
+type SubscriberStats struct {
+	Sent    uint64
+	Dropped uint64
+}
+
+// Delivered returns the number of updates which were not dropped, this
+// includes an update which is still waiting in the channel.
+func (s SubscriberStats) Delivered() uint64 {
+	return s.Sent - s.Dropped
+}
+
+// SubscriberStats returns the delivery counters for the channels returned by
+// Updates which are still open and for those closed by the last Done, in the
+// order the channels were created. It is meant for debugging consumers which
+// are too slow to keep up with the updates.
+func (p *Progress) SubscriberStats() []SubscriberStats {
+	if p == nil {
+		return nil
+	}
+
+	p.fnM.Lock()
+	defer p.fnM.Unlock()
+
+	stats := make([]SubscriberStats, 0, len(p.closedStats)+len(p.updates))
+	stats = append(stats, p.closedStats...)
+	for _, sub := range p.updates {
+		stats = append(stats, sub.SubscriberStats)
+	}
+	return stats
+}
+
+// publish sends u to all channels returned by Updates, replacing an update
+// which has not been received yet. The caller must hold fnM.
+func (p *Progress) publish(u ProgressEvent) {
+	if len(p.updates) == 0 {
+		return
+	}
+
+	p.updateSeq++
+	u.Seq = p.updateSeq
+
+	for _, sub := range p.updates {
+		sub.Sent++
+		select {
+		case sub.ch <- u:
+			continue
+		default:
+		}
+
+		// the consumer did not receive the previous update, drop it
+		select {
+		case <-sub.ch:
+			sub.Dropped++
+		default:
+		}
+
+		// fnM is held, so nobody else can fill the buffer again
+		sub.ch <- u
+	}
+}
+
+// closeUpdates closes all channels returned by Updates and removes them. Only
+// their counters are kept until the next Done, so that they can still be
+// inspected. The caller must hold fnM.
+func (p *Progress) closeUpdates() {
+	p.closedStats = p.closedStats[:0]
+	for _, sub := range p.updates {
+		close(sub.ch)
+		p.closedStats = append(p.closedStats, sub.SubscriberStats)
+	}
+	p.updates = nil
+}
@@ -0,0 +1,216 @@
+package restic
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProgressUpdates(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+	ch := p.Updates()
+
+	p.Start()
+
+	var received []ProgressEvent
+	done := make(chan struct{})
+	go func() {
+		for u := range ch {
+			received = append(received, u)
+		}
+		close(done)
+	}()
+
+	clock.Advance(time.Second)
+	p.Report(Stat{Files: 1, Bytes: 100})
+	clock.Advance(time.Second)
+	p.Done()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("channel was not closed after Done")
+	}
+
+	if len(received) == 0 {
+		t.Fatal("no updates received")
+	}
+
+	last := received[len(received)-1]
+	want := ProgressEvent{Stat: Stat{Files: 1, Bytes: 100}, Runtime: 2 * time.Second, Seq: 2}
+	if last != want {
+		t.Errorf("wrong final update, want %v, got %v", want, last)
+	}
+}
+
+func TestProgressUpdatesSlowConsumer(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+	ch := p.Updates()
+
+	p.Start()
+	for i := 0; i < 10; i++ {
+		clock.Advance(time.Second)
+		p.Report(Stat{Files: 1})
+	}
+
+	// nobody received the updates so far, only the latest is kept
+	u := <-ch
+	if u.Stat.Files != 10 {
+		t.Errorf("expected latest update with 10 files, got %v", u.Stat)
+	}
+
+	p.Done()
+
+	u, ok := <-ch
+	if !ok || u.Stat.Files != 10 {
+		t.Errorf("expected final update with 10 files, got %v (ok %v)", u.Stat, ok)
+	}
+
+	if _, ok := <-ch; ok {
+		t.Errorf("channel not closed after the final update")
+	}
+}
+
+func TestProgressSubscriberStats(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+	slow := p.Updates()
+	fast := p.Updates()
+
+	p.Start()
+
+	var fastReceived uint64
+	for i := 0; i < 10; i++ {
+		clock.Advance(time.Second)
+		p.Report(Stat{Files: 1})
+		<-fast
+		fastReceived++
+	}
+
+	// the slow consumer only receives the latest update
+	<-slow
+	p.Done()
+
+	stats := p.SubscriberStats()
+	if len(stats) != 2 {
+		t.Fatalf("expected stats for 2 subscribers, got %v", stats)
+	}
+
+	if stats[0].Sent != 11 || stats[0].Dropped != 9 {
+		t.Errorf("wrong stats for the slow subscriber: %+v", stats[0])
+	}
+	if stats[0].Delivered() != 2 {
+		t.Errorf("expected 2 updates delivered to the slow subscriber, got %d", stats[0].Delivered())
+	}
+
+	if stats[1].Dropped != 0 || stats[1].Sent != fastReceived+1 {
+		t.Errorf("wrong stats for the fast subscriber: %+v", stats[1])
+	}
+}
+
+func TestProgressUpdatesSeq(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+	ch := p.Updates()
+
+	p.Start()
+
+	var seqs []uint64
+	for i := 0; i < 3; i++ {
+		clock.Advance(time.Second)
+		p.Report(Stat{Files: 1})
+		seqs = append(seqs, (<-ch).Seq)
+	}
+
+	// the consumer is too slow for the next updates, they are coalesced
+	for i := 0; i < 5; i++ {
+		clock.Advance(time.Second)
+		p.Report(Stat{Files: 1})
+	}
+	seqs = append(seqs, (<-ch).Seq)
+
+	p.Done()
+	seqs = append(seqs, (<-ch).Seq)
+
+	want := []uint64{1, 2, 3, 8, 9}
+	if len(seqs) != len(want) {
+		t.Fatalf("wrong sequence numbers, want %v, got %v", want, seqs)
+	}
+	for i := range want {
+		if seqs[i] != want[i] {
+			t.Fatalf("wrong sequence numbers, want %v, got %v", want, seqs)
+		}
+	}
+
+	// the gap matches the number of dropped updates
+	if gap, dropped := seqs[3]-seqs[2]-1, p.SubscriberStats()[0].Dropped; gap != dropped {
+		t.Errorf("gap of %d updates, but %d were dropped", gap, dropped)
+	}
+}
+
+func TestProgressUpdatesEvents(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock))
+	if err := p.SetInterval(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	ch := p.Updates()
+
+	p.Start()
+
+	next := func() ProgressEvent {
+		select {
+		case ev := <-ch:
+			return ev
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for an event")
+			return ProgressEvent{}
+		}
+	}
+
+	p.Report(Stat{Files: 1, Bytes: 100})
+	clock.Advance(time.Second)
+	ev := next()
+	if !ev.Ticker || ev.Runtime != time.Second || ev.Stat.Files != 1 {
+		t.Errorf("wrong event from the ticker: %+v", ev)
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	p.Flush()
+	ev = next()
+	if ev.Ticker || ev.Runtime != 1500*time.Millisecond {
+		t.Errorf("wrong event from Flush: %+v", ev)
+	}
+
+	p.Done()
+	ev = next()
+	if ev.Ticker || ev.Runtime != 1500*time.Millisecond || ev.Stat.Bytes != 100 {
+		t.Errorf("wrong final event: %+v", ev)
+	}
+}
+
+func TestProgressUpdatesRemovedAfterDone(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+
+	for run := 0; run < 3; run++ {
+		ch := p.Updates()
+		p.Start()
+		p.Report(Stat{Files: 1})
+		p.Done()
+
+		for range ch {
+		}
+
+		// only the subscriber of this run is left
+		if stats := p.SubscriberStats(); len(stats) != 1 {
+			t.Errorf("run %d: expected stats for one subscriber, got %v", run, stats)
+		}
+	}
+
+	p.fnM.Lock()
+	defer p.fnM.Unlock()
+	if len(p.updates) != 0 {
+		t.Errorf("%d closed subscribers kept", len(p.updates))
+	}
+}
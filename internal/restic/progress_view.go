@@ -0,0 +1,51 @@
+package restic
+
+import "time"
+
+// ReadOnlyProgress is a restricted view of a Progress which only allows
+// querying its state. It can be handed to display code which must not call
+// Start, Reset, Report or Done.
+type ReadOnlyProgress interface {
+	Current() Stat
+	Snapshot() ProgressEvent
+	Elapsed() time.Duration
+	PercentDone() float64
+	ETA() time.Duration
+}
+
+var _ ReadOnlyProgress = &Progress{}
+
+// CounterView exposes statistics which never decrease, as needed for
+// counters exported to a monitoring system.
+type CounterView interface {
+	LifetimeStat() Stat
+}
+
+var _ CounterView = &Progress{}
+
+// LifetimeStat returns the statistics reported since p was created. Unlike
+// Current, they are not reset by Reset or Start, so they keep growing across
+// the phases and runs of an operation.
+func (p *Progress) LifetimeStat() Stat {
+	if p == nil {
+		return Stat{}
+	}
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+	return p.lifetime
+}
+
+// Snapshot returns the accumulated statistics together with the time since
+// Start() and the maximum depth, all read under the same lock. Calling
+// Current and Elapsed one after the other may return values which do not
+// belong together when a Report happens in between.
+func (p *Progress) Snapshot() ProgressEvent {
+	if p == nil {
+		return ProgressEvent{}
+	}
+
+	p.curM.Lock()
+	defer p.curM.Unlock()
+	return ProgressEvent{Stat: p.curLocked(), Runtime: p.elapsed(p.now()), MaxDepth: p.maxDepth}
+}
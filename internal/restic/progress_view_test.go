@@ -0,0 +1,119 @@
+package restic
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestReadOnlyProgressMethods(t *testing.T) {
+	typ := reflect.TypeOf((*ReadOnlyProgress)(nil)).Elem()
+
+	var names []string
+	for i := 0; i < typ.NumMethod(); i++ {
+		names = append(names, typ.Method(i).Name)
+	}
+	sort.Strings(names)
+
+	want := []string{"Current", "ETA", "Elapsed", "PercentDone", "Snapshot"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("wrong methods, want %v, got %v", want, names)
+	}
+}
+
+func TestReadOnlyProgressView(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker())
+	p.SetTotal(Stat{Bytes: 400})
+	p.Start()
+	defer p.Done()
+
+	var view ReadOnlyProgress = p
+
+	clock.Advance(2 * time.Second)
+	p.Report(Stat{Files: 1, Bytes: 100})
+
+	snap := view.Snapshot()
+	if snap.Stat != view.Current() {
+		t.Errorf("snapshot %v does not match current %v", snap.Stat, view.Current())
+	}
+	if snap.Runtime != 2*time.Second || view.Elapsed() != 2*time.Second {
+		t.Errorf("wrong runtime %v, elapsed %v", snap.Runtime, view.Elapsed())
+	}
+	if pct := view.PercentDone(); pct != 25 {
+		t.Errorf("wrong percentage %v", pct)
+	}
+	if eta := view.ETA(); eta != 6*time.Second {
+		t.Errorf("wrong ETA %v", eta)
+	}
+}
+
+func TestProgressSnapshotConsistent(t *testing.T) {
+	fc := newFakeClock()
+	p := NewProgress(WithClock(fc), WithoutTicker())
+	p.Start()
+	defer p.Done()
+
+	// every Report is one second after the previous one
+	for i := 0; i < 10; i++ {
+		fc.Advance(time.Second)
+		p.Report(Stat{Bytes: 1})
+	}
+
+	snap := p.Snapshot()
+	if uint64(snap.Runtime/time.Second) != snap.Stat.Bytes {
+		t.Errorf("snapshot not consistent: %v after %v", snap.Stat, snap.Runtime)
+	}
+
+	// snapshots taken while reports arrive never go backwards
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			fc.Advance(time.Millisecond)
+			p.Report(Stat{Bytes: 1})
+		}
+	}()
+
+	var last ProgressEvent
+	for i := 0; i < 1000; i++ {
+		snap := p.Snapshot()
+		if snap.Stat.Bytes < last.Stat.Bytes || snap.Runtime < last.Runtime {
+			t.Fatalf("snapshot went backwards from %v to %v", last, snap)
+		}
+		last = snap
+	}
+	<-done
+}
+
+func TestProgressLifetimeStat(t *testing.T) {
+	p := NewProgress(WithoutTicker())
+	p.Start()
+
+	// first phase
+	p.Report(Stat{Files: 2, Bytes: 100})
+	p.Reset()
+
+	// second phase
+	p.Report(Stat{Files: 1, Bytes: 50, Errors: 1})
+	if s := p.Current(); s != (Stat{Files: 1, Bytes: 50, Errors: 1}) {
+		t.Errorf("Current not restarted by Reset: %v", s)
+	}
+	if s := p.LifetimeStat(); s != (Stat{Files: 3, Bytes: 150, Errors: 1}) {
+		t.Errorf("wrong lifetime statistics after Reset: %v", s)
+	}
+	p.Done()
+
+	// a new run restarts Current, but not the lifetime statistics
+	p.Start()
+	defer p.Done()
+	if s := p.Current(); s != (Stat{}) {
+		t.Errorf("Current not zero after Start: %v", s)
+	}
+
+	p.Report(Stat{Bytes: 10})
+	if s := p.LifetimeStat(); s != (Stat{Files: 3, Bytes: 160, Errors: 1}) {
+		t.Errorf("wrong lifetime statistics after restarting: %v", s)
+	}
+}
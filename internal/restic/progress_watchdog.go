@@ -0,0 +1,59 @@
+package restic
+
+import "github.com/restic/restic/internal/errors"
+
+// runWithTimeout runs fn in a new goroutine and waits at most for the
+// callback timeout on the clock for it to return. A panic in fn is passed on
+// to the caller if fn returns in time, a later one is reported to
+// OnCallbackError while holding fnM. The caller must hold fnM.
+func (p *Progress) runWithTimeout(fn func()) {
+	if p.abandoned != nil {
+		select {
+		case <-p.abandoned:
+			p.abandoned = nil
+		default:
+			// the previous call is still running, drop this update
+			return
+		}
+	}
+
+	done := make(chan interface{}, 1)
+	go func() {
+		done <- catchPanic(fn)
+	}()
+
+	// the first tick of a ticker from the clock serves as the timer
+	timer := newTicker(p.clock, p.callbackTimeout)
+	defer timer.Stop()
+
+	select {
+	case panicked := <-done:
+		if panicked != nil {
+			panic(panicked)
+		}
+		return
+	case <-timer.Chan():
+	}
+
+	abandoned := make(chan struct{})
+	p.abandoned = abandoned
+	p.callbackError(errors.Errorf("OnUpdate did not return within %v, abandoning it", p.callbackTimeout))
+
+	go func() {
+		defer close(abandoned)
+		panicked := <-done
+		if panicked == nil {
+			return
+		}
+		p.callLocked(func() {
+			p.callbackError(errors.Errorf("abandoned OnUpdate panicked: %v", panicked))
+		})
+	}()
+}
+
+// callbackError passes err to OnCallbackError, if it is set.
+func (p *Progress) callbackError(err error) {
+	if p.OnCallbackError != nil {
+		p.OnCallbackError(err)
+	}
+}
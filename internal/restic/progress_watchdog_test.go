@@ -0,0 +1,139 @@
+package restic
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// advanceUntil advances clock by step until done is closed or fails the test
+// after a while.
+func advanceUntil(t testing.TB, clock *fakeClock, step time.Duration, done <-chan struct{}) {
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case <-done:
+			return
+		case <-deadline:
+			t.Fatal("timeout while advancing the clock")
+		case <-time.After(time.Millisecond):
+			clock.Advance(step)
+		}
+	}
+}
+
+func TestProgressCallbackTimeout(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker(), WithCallbackTimeout(time.Minute))
+
+	var m sync.Mutex
+	var errs []error
+	p.OnCallbackError = func(err error) {
+		m.Lock()
+		errs = append(errs, err)
+		m.Unlock()
+	}
+
+	unblock := make(chan struct{})
+	calls := 0
+	p.OnUpdate = func(s Stat, d time.Duration, ticker bool) {
+		m.Lock()
+		calls++
+		m.Unlock()
+		<-unblock
+	}
+
+	p.Start()
+
+	returned := make(chan struct{})
+	go func() {
+		p.Report(Stat{Files: 1})
+		close(returned)
+	}()
+
+	// the timeout is driven by the clock
+	advanceUntil(t, clock, time.Minute, returned)
+
+	m.Lock()
+	if len(errs) != 1 {
+		t.Errorf("expected one error for the timeout, got %v", errs)
+	}
+	m.Unlock()
+
+	// while the callback is still running, updates are dropped
+	p.updateProgress(p.Current(), false)
+
+	close(unblock)
+	p.Done()
+
+	m.Lock()
+	defer m.Unlock()
+	if calls != 1 {
+		t.Errorf("expected one call while the callback was blocked, got %d", calls)
+	}
+}
+
+func TestProgressCallbackTimeoutFast(t *testing.T) {
+	p := NewProgress(WithoutTicker(), WithCallbackTimeout(time.Minute))
+	p.OnCallbackError = func(err error) {
+		t.Errorf("unexpected error %v", err)
+	}
+	rec := NewProgressRecorder(p)
+
+	p.Start()
+	p.Report(Stat{Files: 1})
+	p.Done()
+
+	if len(rec.Updates()) != 2 {
+		t.Errorf("expected two updates, got %v", rec.Updates())
+	}
+}
+
+func TestProgressCallbackTimeoutLatePanic(t *testing.T) {
+	clock := newFakeClock()
+	p := NewProgress(WithClock(clock), WithoutTicker(), WithCallbackTimeout(time.Second))
+
+	var m sync.Mutex
+	var errs []error
+	lateError := make(chan struct{})
+	p.OnCallbackError = func(err error) {
+		m.Lock()
+		defer m.Unlock()
+		errs = append(errs, err)
+		if len(errs) == 2 {
+			close(lateError)
+		}
+	}
+
+	unblock := make(chan struct{})
+	p.OnUpdate = func(s Stat, d time.Duration, ticker bool) {
+		<-unblock
+		panic("late failure")
+	}
+
+	p.Start()
+
+	returned := make(chan struct{})
+	go func() {
+		p.Report(Stat{Files: 1})
+		close(returned)
+	}()
+	advanceUntil(t, clock, time.Second, returned)
+
+	// the late error is serialized with the other callbacks
+	p.fnM.Lock()
+	close(unblock)
+	select {
+	case <-lateError:
+		t.Error("late error reported while another callback was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	p.fnM.Unlock()
+
+	select {
+	case <-lateError:
+	case <-time.After(5 * time.Second):
+		t.Fatal("late panic was not reported")
+	}
+	p.Done()
+}